@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/validator"
+)
+
+// Add a listAPIKeysHandler for the "GET /v1/api-keys" endpoint. It lists the keys
+// belonging to the current user; the plaintext keys themselves are never shown again.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(map[string]any{"api_keys": keys}, w, r)
+}
+
+// Add a createAPIKeyHandler for the "POST /v1/api-keys" endpoint. The permissions
+// given to the key must be a subset of the current user's own permissions.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Label       string     `json:"label"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	granted, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Label:       input.Label,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, granted); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(r.Context(), user.ID, key.Label, key.Permissions, key.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//This response is the only time the plaintext key is sent to the client, so
+	//it needs to be stored somewhere safe straight away
+	headers := make(http.Header)
+	headers.Set("Location", "/v1/api-keys")
+
+	app.writeJsonStatus(map[string]any{"api_key": key}, w, r, http.StatusCreated, headers)
+}
+
+// Add a deleteAPIKeyHandler for the "DELETE /v1/api-keys/:id" endpoint, which revokes
+// one of the current user's keys.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.APIKeys.Delete(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(map[string]any{"message": "api key successfully deleted"}, w, r)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/jwtauth"
+)
+
+// Define a custom contextKey type, with the underlying type string, so that our
+// context keys can't collide with keys set by other packages.
+type contextKey string
+
+// Convert the string "user" to a contextKey type and assign it to the userContextKey
+// constant. We'll use this constant as the key for getting and setting user
+// information in the request context.
+const userContextKey = contextKey("user")
+
+// apiKeyContextKey is used to store the API key a request was authenticated with,
+// if any, so that requirePermission() can apply the key's narrower scopes.
+const apiKeyContextKey = contextKey("api_key")
+
+// jwtClaimsContextKey is used to store the claims of the JWT a request was
+// authenticated with in JWT mode, so that logging out can revoke the token.
+const jwtClaimsContextKey = contextKey("jwt_claims")
+
+// requestInfoContextKey is used to store the requestInfo for each request.
+const requestInfoContextKey = contextKey("request_info")
+
+// requestInfo holds details about a request which are needed for logging. It is added
+// to the context by the requestID() middleware, before the user is known, so it is
+// stored as a pointer and contextSetUser() fills in the user ID later on. This lets
+// the access log line written by logRequest() include the user ID.
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+// The contextSetUser() method returns a new copy of the request with the provided
+// User struct added to the context. It also records the user's ID in the request's
+// requestInfo for the access log.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info := contextGetRequestInfo(r.Context()); info != nil && !user.IsAnonymous() {
+		info.userID = user.ID
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() retrieves the User struct from the request context. The only
+// time that we'll use this helper is when we logically expect there to be a User struct
+// value in the context, and if it doesn't exist it will firmly be an 'unexpected' error,
+// so we panic.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// The contextSetAPIKey() method returns a new copy of the request with the provided
+// APIKey struct added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() method retrieves the APIKey struct from the request context.
+// Unlike contextGetUser() it is normal for there to be no key, in which case it
+// returns nil.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// The contextSetJWTClaims() method returns a new copy of the request with the provided
+// JWT claims added to the context.
+func (app *application) contextSetJWTClaims(r *http.Request, claims *jwtauth.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), jwtClaimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// The contextGetJWTClaims() method retrieves the JWT claims from the request context,
+// returning nil if the request wasn't authenticated with a JWT.
+func (app *application) contextGetJWTClaims(r *http.Request) *jwtauth.Claims {
+	claims, _ := r.Context().Value(jwtClaimsContextKey).(*jwtauth.Claims)
+	return claims
+}
+
+// The contextSetRequestInfo() method returns a new copy of the request with the
+// provided requestInfo added to the context.
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo retrieves the requestInfo from a context, returning nil if
+// there isn't one. It takes a context rather than a request so that the logger's
+// contextHandler can use it too.
+func contextGetRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
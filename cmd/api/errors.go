@@ -1,10 +1,56 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/i18n"
+	"github.com/greenlight-api/validator"
+)
+
+// Stable, machine-readable codes for each kind of error response. Clients should
+// switch on these rather than on the human-readable title or detail, which may
+// change wording over time.
+const (
+	codeServerError      = "server_error"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeBadRequest       = "bad_request"
+	codeFailedValidation = "failed_validation"
+	codeEditConflict     = "edit_conflict"
+	codeRateLimited      = "rate_limit_exceeded"
+	codeInvalidCreds     = "invalid_credentials"
+	codeInvalidToken     = "invalid_authentication_token"
+	codeAuthRequired     = "authentication_required"
+	codeInactiveAccount  = "inactive_account"
+	codeNotPermitted     = "not_permitted"
+	codeAPIKeyNotAllowed = "api_key_not_allowed"
+	codeUnavailable      = "service_unavailable"
 )
 
+// problem is an RFC 7807 "problem details" document. Type is a URI identifying the
+// kind of problem, Title is the standard status text and Instance is the request
+// URI that produced the problem. Code, RequestID and Errors are extension members:
+// Code is our stable error code, RequestID lets the client quote the request when
+// reporting a problem, and Errors carries the list of validation errors for each field.
+type problem struct {
+	Type      string                            `json:"type"`
+	Title     string                            `json:"title"`
+	Status    int                               `json:"status"`
+	Detail    string                            `json:"detail"`
+	Instance  string                            `json:"instance"`
+	Code      string                            `json:"code"`
+	RequestID string                            `json:"request_id,omitempty"`
+	Errors    map[string][]validator.FieldError `json:"errors,omitempty"`
+
+	//detailCode is the catalogue key of the localized detail message, when it is
+	//different from Code. params holds any values substituted into that message
+	detailCode string
+	params     map[string]string
+}
+
 // The logError() method is ageneric helper for logging an error message
 // along with the current request method and URL as attribute in the log entry
 func (app *application) logError(r *http.Request, err error) {
@@ -12,45 +58,230 @@ func (app *application) logError(r *http.Request, err error) {
 		method = r.Method
 		uri    = r.URL.RequestURI()
 	)
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 }
 
-// The errorResponse() Method is a generic helper for sending JSON-formatted error messages
-// to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	data := map[string]any{"error": message}
+// The errorResponse() Method is a generic helper for sending application/problem+json
+// error documents to the client with a given status code, error code and detail message.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	app.problemResponse(w, r, problem{
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+}
+
+// The problemResponse() method fills in the fields of a problem document which are
+// derived from the request and status code, then writes it to the client.
+//
+// The detail and any validation messages are translated into the language the client
+// asked for in its Accept-Language header, and Vary tells caches that the response
+// depends on that header. Codes without a catalogue entry are sent as they are.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = "urn:greenlight:problem:" + p.Code
+	p.Title = http.StatusText(p.Status)
+	p.Instance = r.URL.RequestURI()
+	if info := contextGetRequestInfo(r.Context()); info != nil {
+		p.RequestID = info.id
+	}
+
+	detailCode := p.detailCode
+	if detailCode == "" {
+		detailCode = p.Code
+	}
 
-	//Write the response using the writeJSON() helper.If this happens to
+	lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+	if detail, ok := i18n.Translate(lang, detailCode, p.params); ok {
+		p.Detail = detail
+	}
+	p.Errors = localizeErrors(lang, p.Errors)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+
+	//Write the response using the writeProblem() helper.If this happens to
 	//return an error then we log it, and fall back to sending the client
 	//an empty response with a 500 internal server error status code
-	app.writeJson(data,w,r)
+	err := app.writeProblem(w, p.Status, p)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// localizeErrors returns a copy of a validation errors map with each message rendered
+// in the given language, keeping the original message where there is no translation.
+func localizeErrors(lang string, errors map[string][]validator.FieldError) map[string][]validator.FieldError {
+	if errors == nil {
+		return nil
+	}
+
+	localized := make(map[string][]validator.FieldError, len(errors))
+	for key, fieldErrors := range errors {
+		for _, fe := range fieldErrors {
+			if message, ok := i18n.Translate(lang, fe.Code, fe.Params); ok {
+				fe.Message = message
+			}
+			localized[key] = append(localized[key], fe)
+		}
+	}
+	return localized
 }
 
 // The serverErrorResponse() method is used when our application encounters an
 // unexpected problem at runtime. It logs the detailed error message, then uses
 // the errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client
+//
+// Database queries which were cut short are handled here too, since every handler
+// already sends unexpected model errors to this method: a query that timed out gets a
+// 503 Service Unavailable response, and one canceled because the client went away
+// gets the (nginx-style) 499 Client Closed Request status.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrQueryTimeout):
+		app.serviceUnavailableResponse(w, r, err)
+		return
+	case errors.Is(err, data.ErrQueryCanceled):
+		app.clientClosedRequestResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
 	message := "The server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message)
+}
+
+// The serviceUnavailableResponse() method will be used to send a 503 Service
+// Unavailable status code when a database query takes longer than the query timeout.
+// The Retry-After header suggests the client tries again shortly.
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	w.Header().Set("Retry-After", "1")
+
+	message := "the server is temporarily unable to handle your request, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, codeUnavailable, message)
+}
+
+// statusClientClosedRequest is the non-standard status code nginx uses for requests
+// where the client closed the connection before the response was sent.
+const statusClientClosedRequest = 499
+
+// The clientClosedRequestResponse() method is used when a request's context has been
+// canceled because the client went away. There's nobody to read a response body, so we
+// only log the event and record the 499 status for the access log and metrics.
+func (app *application) clientClosedRequestResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.InfoContext(r.Context(), "client closed request", "method", r.Method, "uri", r.URL.RequestURI())
+	w.WriteHeader(statusClientClosedRequest)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "The requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message)
 }
 
 // The methodNotAllowedResponse() method will be used to send a 405 method Not allowed
 // status code and JSON response to the client
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.problemResponse(w, r, problem{
+		Status: http.StatusMethodNotAllowed,
+		Code:   codeMethodNotAllowed,
+		Detail: message,
+		params: map[string]string{"method": r.Method},
+	})
 }
 
+// The badRequestResponse() method will be used to send a 400 Bad Request status code
+// when the request can't be understood, e.g. because the body can't be decoded. A
+// *requestError from readJSON() explains what is wrong, and is sent as the detail in
+// the client's language. Any other error gets a generic detail message.
+func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	p := problem{
+		Status: http.StatusBadRequest,
+		Code:   codeBadRequest,
+		Detail: err.Error(),
+	}
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		p.detailCode = reqErr.code
+		p.params = reqErr.params
+	}
+
+	app.problemResponse(w, r, p)
+}
+
+// The failedValidationResponse() method will be used to send a 422 Unprocessable Entity
+// status code along with the per-field error messages from a Validator instance.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string][]validator.FieldError) {
+	app.problemResponse(w, r, problem{
+		Status: http.StatusUnprocessableEntity,
+		Code:   codeFailedValidation,
+		Detail: "one or more fields failed validation",
+		Errors: errors,
+	})
+}
+
+// The editConflictResponse() method will be used to send a 409 Conflict status code
+// and JSON response to the client when an update fails because the record was
+// changed by someone else in the meantime
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message)
+}
+
+// The rateLimitExceededResponse() method will be used to send a 429 Too Many Requests
+// status code when a client has used up its request allowance
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimited, message)
+}
+
+// The invalidCredentialsResponse() method will be used to send a 401 Unauthorized
+// status code when the email address or password supplied by the client is wrong
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCreds, message)
+}
+
+// The invalidAuthenticationTokenResponse() method will be used to send a 401
+// Unauthorized status code when the bearer token is missing, malformed or expired.
+// We include a WWW-Authenticate header to remind the client how to authenticate
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidToken, message)
+}
+
+// The authenticationRequiredResponse() method will be used to send a 401 Unauthorized
+// status code when an anonymous user tries to use an endpoint which needs a login
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthRequired, message)
+}
+
+// The inactiveAccountResponse() method will be used to send a 403 Forbidden status
+// code when the user hasn't activated their account yet
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message)
+}
+
+// The notPermittedResponse() method will be used to send a 403 Forbidden status code
+// when the user doesn't hold the permission an endpoint requires
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message)
+}
+
+// The apiKeyNotAllowedResponse() method will be used to send a 403 Forbidden status
+// code when a request authenticated with an API key tries to use an endpoint which
+// needs the user's own credentials
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key, please authenticate with a user token instead"
+	app.errorResponse(w, r, http.StatusForbidden, codeAPIKeyNotAllowed, message)
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greenlight-api/internal/data"
+)
+
+func TestServerErrorResponse(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"query timeout", fmt.Errorf("%w: %w", data.ErrQueryTimeout, context.DeadlineExceeded), http.StatusServiceUnavailable, codeUnavailable},
+		{"query canceled", fmt.Errorf("%w: %w", data.ErrQueryCanceled, context.Canceled), statusClientClosedRequest, ""},
+		{"other error", errors.New("boom"), http.StatusInternalServerError, codeServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.serverErrorResponse(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil), tt.err)
+			checkStatus(t, rr, tt.status)
+
+			//A client which has gone away isn't sent a body
+			if tt.code == "" {
+				if rr.Body.Len() != 0 {
+					t.Errorf("got body %q; want none", rr.Body)
+				}
+				return
+			}
+			if got := problemCode(t, rr); got != tt.code {
+				t.Errorf("got problem code %q; want %q", got, tt.code)
+			}
+		})
+	}
+}
+
+// failingMovies is a MovieRepository whose GetAll() always fails with err.
+type failingMovies struct {
+	data.MovieRepository
+	err error
+}
+
+func (m failingMovies) GetAll(ctx context.Context, title string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	return nil, data.Metadata{}, m.err
+}
+
+func TestQueryErrorResponses(t *testing.T) {
+	app, _ := newTestApplication(t)
+	_, token := newTestUser(t, app, "alice@example.com", true, "movies:read")
+	h := app.routes()
+
+	//A query which runs out of time gets a 503 telling the client to retry
+	app.models.Movies = failingMovies{app.models.Movies, fmt.Errorf("%w: %w", data.ErrQueryTimeout, context.DeadlineExceeded)}
+
+	rr := send(t, h, http.MethodGet, "/v1/movies", token, "")
+	checkStatus(t, rr, http.StatusServiceUnavailable)
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q; want 1", got)
+	}
+	if got := problemCode(t, rr); got != codeUnavailable {
+		t.Errorf("got problem code %q; want %q", got, codeUnavailable)
+	}
+
+	//A query cut short because the client went away is recorded as a 499
+	app.models.Movies = failingMovies{app.models.Movies, fmt.Errorf("%w: %w", data.ErrQueryCanceled, context.Canceled)}
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", token, "")
+	checkStatus(t, rr, statusClientClosedRequest)
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/jwtauth"
+	"github.com/greenlight-api/internal/mailer"
+	"github.com/greenlight-api/internal/metrics"
+)
+
+// newTestApplication returns an application which keeps its records in the in-memory
+// models and its emails in an in-memory mailer, so that requests can be sent through
+// app.routes() without a database or an SMTP server. Rate limiting is off.
+func newTestApplication(t *testing.T) (*application, *mailer.Memory) {
+	t.Helper()
+
+	mail := mailer.NewMemory(10, nil)
+
+	app := &application{
+		config:  config{env: "development"},
+		logger:  slog.New(slog.DiscardHandler),
+		models:  data.NewMockModels(),
+		mailer:  mail,
+		metrics: metrics.New(version),
+	}
+
+	return app, mail
+}
+
+// send makes a request to the handler and returns the recorded response. headers are
+// given as name, value pairs, and an empty token means no Authorization header.
+func send(t *testing.T, h http.Handler, method, target, token, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
+
+// decode unmarshals a response body into dst, failing the test if it isn't JSON.
+func decode(t *testing.T, rr *httptest.ResponseRecorder, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(rr.Body.Bytes(), dst)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", rr.Body.String(), err)
+	}
+}
+
+// problemCode returns the code member of a problem document response.
+func problemCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var p struct {
+		Code string `json:"code"`
+	}
+	decode(t, rr, &p)
+	return p.Code
+}
+
+// checkStatus fails the test if the response doesn't have the wanted status code.
+func checkStatus(t *testing.T, rr *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if rr.Code != want {
+		t.Fatalf("got status %d; want %d (body %s)", rr.Code, want, rr.Body.String())
+	}
+}
+
+// newTestUser adds a user with the password "pa55word" and the given permissions
+// straight to the models, and returns the user along with an authentication token.
+func newTestUser(t *testing.T, app *application, email string, activated bool, permissions ...string) (*data.User, string) {
+	t.Helper()
+	ctx := t.Context()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: activated}
+	err := user.Password.Set("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Permissions.AddForUser(ctx, user.ID, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// emailedToken waits for the background email goroutines, then returns the token
+// in the latest email sent to the recipient.
+func emailedToken(t *testing.T, app *application, mail *mailer.Memory, recipient string) string {
+	t.Helper()
+
+	app.wg.Wait()
+
+	messages := mail.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Recipient != recipient {
+			continue
+		}
+		match := regexp.MustCompile(`"token": "([A-Z2-7]{26})"`).FindStringSubmatch(messages[i].PlainBody)
+		if match == nil {
+			t.Fatalf("no token in email %q", messages[i].PlainBody)
+		}
+		return match[1]
+	}
+
+	t.Fatalf("no email sent to %s", recipient)
+	return ""
+}
+
+type movieResponse struct {
+	Movie struct {
+		ID      int64    `json:"id"`
+		Title   string   `json:"title"`
+		Year    int32    `json:"year"`
+		Runtime int32    `json:"runtime"`
+		Genres  []string `json:"genres"`
+		Version int32    `json:"version"`
+	} `json:"movie"`
+}
+
+func TestMovieCRUD(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+	_, token := newTestUser(t, app, "curator@example.com", true, "movies:read", "movies:write")
+
+	//Create
+	rr := send(t, h, http.MethodPost, "/v1/movies", token, `{"title":"Casablanca","year":1942,"runtime":102,"genres":["drama","romance"]}`)
+	checkStatus(t, rr, http.StatusCreated)
+
+	var created movieResponse
+	decode(t, rr, &created)
+	if created.Movie.ID == 0 || created.Movie.Version != 1 {
+		t.Fatalf("got movie %+v; want an ID and version 1", created.Movie)
+	}
+	if got := rr.Header().Get("Location"); got != "/v1/movies/1" {
+		t.Errorf("got Location %q; want %q", got, "/v1/movies/1")
+	}
+
+	//Read
+	rr = send(t, h, http.MethodGet, "/v1/movies/1", token, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	var shown movieResponse
+	decode(t, rr, &shown)
+	if shown.Movie.Title != "Casablanca" || shown.Movie.Runtime != 102 {
+		t.Errorf("got movie %+v", shown.Movie)
+	}
+
+	//PATCH only changes the fields supplied
+	rr = send(t, h, http.MethodPatch, "/v1/movies/1", token, `{"runtime":103}`)
+	checkStatus(t, rr, http.StatusOK)
+
+	var patched movieResponse
+	decode(t, rr, &patched)
+	if patched.Movie.Title != "Casablanca" || patched.Movie.Runtime != 103 || patched.Movie.Version != 2 {
+		t.Errorf("got movie %+v after PATCH", patched.Movie)
+	}
+
+	//PUT replaces the whole movie, so leaving fields out fails validation
+	rr = send(t, h, http.MethodPut, "/v1/movies/1", token, `{"title":"Casablanca"}`)
+	checkStatus(t, rr, http.StatusUnprocessableEntity)
+
+	var failed struct {
+		Errors map[string]any `json:"errors"`
+	}
+	decode(t, rr, &failed)
+	for _, key := range []string{"year", "runtime", "genres"} {
+		if _, ok := failed.Errors[key]; !ok {
+			t.Errorf("got errors %v; want one for %q", failed.Errors, key)
+		}
+	}
+
+	rr = send(t, h, http.MethodPut, "/v1/movies/1", token, `{"title":"Casablanca","year":1943,"runtime":102,"genres":["drama"]}`)
+	checkStatus(t, rr, http.StatusOK)
+
+	var replaced movieResponse
+	decode(t, rr, &replaced)
+	if replaced.Movie.Year != 1943 || !slices.Equal(replaced.Movie.Genres, []string{"drama"}) || replaced.Movie.Version != 3 {
+		t.Errorf("got movie %+v after PUT", replaced.Movie)
+	}
+
+	//List
+	rr = send(t, h, http.MethodGet, "/v1/movies?title=casablanca&genres=drama", token, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	var list struct {
+		Movies   []map[string]any `json:"movies"`
+		Metadata struct {
+			TotalRecords int `json:"total_records"`
+		} `json:"metadata"`
+	}
+	decode(t, rr, &list)
+	if len(list.Movies) != 1 || list.Metadata.TotalRecords != 1 {
+		t.Errorf("got %d movies (total %d); want 1", len(list.Movies), list.Metadata.TotalRecords)
+	}
+
+	//Delete
+	rr = send(t, h, http.MethodDelete, "/v1/movies/1", token, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies/1", token, "")
+	checkStatus(t, rr, http.StatusNotFound)
+
+	rr = send(t, h, http.MethodDelete, "/v1/movies/1", token, "")
+	checkStatus(t, rr, http.StatusNotFound)
+}
+
+func TestMovieEditConflict(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+	_, token := newTestUser(t, app, "curator@example.com", true, "movies:read", "movies:write")
+
+	rr := send(t, h, http.MethodPost, "/v1/movies", token, `{"title":"Casablanca","year":1942,"runtime":102,"genres":["drama"]}`)
+	checkStatus(t, rr, http.StatusCreated)
+
+	badVersion := "the X-Expected-Version header must be a non-negative integer"
+
+	tests := []struct {
+		name     string
+		expected string
+		status   int
+		code     string
+		detail   string
+	}{
+		{"stale version", "5", http.StatusConflict, codeEditConflict, ""},
+		{"not a number", "abc", http.StatusBadRequest, codeBadRequest, badVersion},
+		{"negative", "-1", http.StatusBadRequest, codeBadRequest, badVersion},
+		{"too big", "4294967296", http.StatusBadRequest, codeBadRequest, badVersion},
+		{"current version", "1", http.StatusOK, "", ""},
+		{"version just replaced", "1", http.StatusConflict, codeEditConflict, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(t, h, http.MethodPatch, "/v1/movies/1", token, `{"runtime":110}`, "X-Expected-Version", tt.expected)
+			checkStatus(t, rr, tt.status)
+
+			if tt.code == "" {
+				return
+			}
+
+			var p struct {
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			decode(t, rr, &p)
+			if p.Code != tt.code {
+				t.Errorf("got code %q; want %q", p.Code, tt.code)
+			}
+			if tt.detail != "" && p.Detail != tt.detail {
+				t.Errorf("got detail %q; want %q", p.Detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+	_, inactive := newTestUser(t, app, "inactive@example.com", false, "movies:read")
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		status int
+		code   string
+	}{
+		{"no credentials", http.MethodGet, "", http.StatusUnauthorized, codeAuthRequired},
+		{"not a bearer token", http.MethodGet, "Basic " + reader, http.StatusUnauthorized, codeInvalidToken},
+		{"malformed token", http.MethodGet, "Bearer abc", http.StatusUnauthorized, codeInvalidToken},
+		{"unknown token", http.MethodGet, "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized, codeInvalidToken},
+		{"inactive user", http.MethodGet, "Bearer " + inactive, http.StatusForbidden, codeInactiveAccount},
+		{"missing permission", http.MethodPost, "Bearer " + reader, http.StatusForbidden, codeNotPermitted},
+		{"permitted", http.MethodGet, "Bearer " + reader, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/movies", strings.NewReader(`{}`))
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			checkStatus(t, rr, tt.status)
+			if tt.code != "" {
+				if got := problemCode(t, rr); got != tt.code {
+					t.Errorf("got code %q; want %q", got, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterActivateAndLogin(t *testing.T) {
+	app, mail := newTestApplication(t)
+	h := app.routes()
+
+	rr := send(t, h, http.MethodPost, "/v1/users", "", `{"name":"Alice","email":"alice@example.com","password":"pa55word"}`)
+	checkStatus(t, rr, http.StatusCreated)
+
+	//The email address is taken now, ignoring case
+	rr = send(t, h, http.MethodPost, "/v1/users", "", `{"name":"Alice","email":"ALICE@example.com","password":"pa55word"}`)
+	checkStatus(t, rr, http.StatusUnprocessableEntity)
+
+	//The password is checked before it is hashed
+	rr = send(t, h, http.MethodPost, "/v1/users", "", `{"name":"Bob","email":"bob@example.com","password":"short"}`)
+	checkStatus(t, rr, http.StatusUnprocessableEntity)
+
+	login := func() string {
+		t.Helper()
+
+		rr := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", `{"email":"alice@example.com","password":"pa55word"}`)
+		checkStatus(t, rr, http.StatusCreated)
+
+		var body struct {
+			Token struct {
+				Plaintext string `json:"token"`
+			} `json:"authentication_token"`
+		}
+		decode(t, rr, &body)
+		return body.Token.Plaintext
+	}
+
+	//The account can't be used until it is activated
+	rr = send(t, h, http.MethodGet, "/v1/movies", login(), "")
+	checkStatus(t, rr, http.StatusForbidden)
+
+	activation := emailedToken(t, app, mail, "alice@example.com")
+
+	rr = send(t, h, http.MethodPut, "/v1/users/activated", "", `{"token":"`+activation+`"}`)
+	checkStatus(t, rr, http.StatusOK)
+
+	//Activation tokens are single-use
+	rr = send(t, h, http.MethodPut, "/v1/users/activated", "", `{"token":"`+activation+`"}`)
+	checkStatus(t, rr, http.StatusUnprocessableEntity)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", login(), "")
+	checkStatus(t, rr, http.StatusOK)
+
+	//A wrong password gets a 401
+	rr = send(t, h, http.MethodPost, "/v1/tokens/authentication", "", `{"email":"alice@example.com","password":"wrong-password"}`)
+	checkStatus(t, rr, http.StatusUnauthorized)
+	if got := problemCode(t, rr); got != codeInvalidCreds {
+		t.Errorf("got code %q; want %q", got, codeInvalidCreds)
+	}
+}
+
+func TestPasswordResetDoesNotRevealAccounts(t *testing.T) {
+	app, mail := newTestApplication(t)
+	h := app.routes()
+
+	newTestUser(t, app, "active@example.com", true)
+	newTestUser(t, app, "inactive@example.com", false)
+
+	var bodies []string
+	for _, email := range []string{"active@example.com", "inactive@example.com", "nobody@example.com"} {
+		rr := send(t, h, http.MethodPost, "/v1/tokens/password-reset", "", `{"email":"`+email+`"}`)
+		checkStatus(t, rr, http.StatusAccepted)
+		bodies = append(bodies, rr.Body.String())
+	}
+
+	if bodies[0] != bodies[1] || bodies[0] != bodies[2] {
+		t.Errorf("got different responses %q", bodies)
+	}
+
+	app.wg.Wait()
+	if messages := mail.Messages(); len(messages) != 1 || messages[0].Recipient != "active@example.com" {
+		t.Errorf("got %d emails; want one to active@example.com", len(messages))
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	app, mail := newTestApplication(t)
+	h := app.routes()
+	_, token := newTestUser(t, app, "alice@example.com", true, "movies:read")
+
+	//A key can't be given permissions the user doesn't have
+	rr := send(t, h, http.MethodPost, "/v1/api-keys", token, `{"label":"ci","permissions":["movies:write"]}`)
+	checkStatus(t, rr, http.StatusUnprocessableEntity)
+
+	rr = send(t, h, http.MethodPost, "/v1/api-keys", token, `{"label":"ci","permissions":["movies:read"]}`)
+	checkStatus(t, rr, http.StatusCreated)
+
+	var created struct {
+		Key struct {
+			ID        int64  `json:"id"`
+			Plaintext string `json:"key"`
+		} `json:"api_key"`
+	}
+	decode(t, rr, &created)
+
+	key := created.Key.Plaintext
+	if !strings.HasPrefix(key, data.APIKeyPrefix) {
+		t.Fatalf("got key %q; want the %q prefix", key, data.APIKeyPrefix)
+	}
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", key, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	//The key can't be used to manage keys, or to log out
+	for _, req := range []struct{ method, target string }{
+		{http.MethodGet, "/v1/api-keys"},
+		{http.MethodPost, "/v1/api-keys"},
+		{http.MethodDelete, "/v1/api-keys/1"},
+	} {
+		rr = send(t, h, req.method, req.target, key, `{"label":"more","permissions":["movies:read"]}`)
+		checkStatus(t, rr, http.StatusForbidden)
+		if got := problemCode(t, rr); got != codeAPIKeyNotAllowed {
+			t.Errorf("%s %s: got code %q; want %q", req.method, req.target, got, codeAPIKeyNotAllowed)
+		}
+	}
+
+	rr = send(t, h, http.MethodDelete, "/v1/tokens/authentication", key, "")
+	checkStatus(t, rr, http.StatusBadRequest)
+
+	rr = send(t, h, http.MethodGet, "/v1/api-keys", token, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	//Resetting the password revokes the key
+	rr = send(t, h, http.MethodPost, "/v1/tokens/password-reset", "", `{"email":"alice@example.com"}`)
+	checkStatus(t, rr, http.StatusAccepted)
+
+	reset := emailedToken(t, app, mail, "alice@example.com")
+
+	rr = send(t, h, http.MethodPut, "/v1/users/password", "", `{"password":"new-pa55word","token":"`+reset+`"}`)
+	checkStatus(t, rr, http.StatusOK)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", key, "")
+	checkStatus(t, rr, http.StatusUnauthorized)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", token, "")
+	checkStatus(t, rr, http.StatusUnauthorized)
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	app, mail := newTestApplication(t)
+
+	keys, err := jwtauth.ParseKeys("k1:HS256:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.jwt, err = jwtauth.New(keys, "greenlight", "greenlight-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := app.routes()
+	newTestUser(t, app, "alice@example.com", true, "movies:read")
+
+	login := func() string {
+		t.Helper()
+
+		rr := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", `{"email":"alice@example.com","password":"pa55word"}`)
+		checkStatus(t, rr, http.StatusCreated)
+
+		var body struct {
+			Token struct {
+				Plaintext string `json:"token"`
+			} `json:"authentication_token"`
+		}
+		decode(t, rr, &body)
+		return body.Token.Plaintext
+	}
+	jwt, other := login(), login()
+
+	//The permissions come from the token's claims
+	rr := send(t, h, http.MethodGet, "/v1/movies", jwt, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	rr = send(t, h, http.MethodPost, "/v1/movies", jwt, `{}`)
+	checkStatus(t, rr, http.StatusForbidden)
+
+	rr = send(t, h, http.MethodGet, "/v1/api-keys", jwt, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	//Logging out revokes the token everywhere, but leaves the user's other tokens alone
+	rr = send(t, h, http.MethodDelete, "/v1/tokens/authentication", other, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", other, "")
+	checkStatus(t, rr, http.StatusUnauthorized)
+
+	rr = send(t, h, http.MethodGet, "/v1/movies", jwt, "")
+	checkStatus(t, rr, http.StatusOK)
+
+	//Resetting the password bumps the user's version, so every token issued before
+	//the reset is rejected, whichever endpoint it is used on
+	rr = send(t, h, http.MethodPost, "/v1/tokens/password-reset", "", `{"email":"alice@example.com"}`)
+	checkStatus(t, rr, http.StatusAccepted)
+
+	reset := emailedToken(t, app, mail, "alice@example.com")
+
+	rr = send(t, h, http.MethodPut, "/v1/users/password", "", `{"password":"new-pa55word","token":"`+reset+`"}`)
+	checkStatus(t, rr, http.StatusOK)
+
+	for _, target := range []string{"/v1/movies", "/v1/movies/1", "/v1/api-keys"} {
+		rr = send(t, h, http.MethodGet, target, jwt, "")
+		checkStatus(t, rr, http.StatusUnauthorized)
+		if got := problemCode(t, rr); got != "invalid_authentication_token" {
+			t.Errorf("GET %s: got problem code %q; want invalid_authentication_token", target, got)
+		}
+	}
+
+	rr = send(t, h, http.MethodDelete, "/v1/movies/1", jwt, "")
+	checkStatus(t, rr, http.StatusUnauthorized)
+}
+
+func TestProblemResponsesAreLocalized(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+	_, token := newTestUser(t, app, "curator@example.com", true, "movies:read", "movies:write")
+
+	rr := send(t, h, http.MethodPost, "/v1/movies", token, `{"title":`, "Accept-Language", "fr-CA, en;q=0.5")
+	checkStatus(t, rr, http.StatusBadRequest)
+
+	var p struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	}
+	decode(t, rr, &p)
+
+	if p.Code != codeBadRequest || p.Detail != "le corps contient du JSON mal formé" {
+		t.Errorf("got code %q and detail %q", p.Code, p.Detail)
+	}
+	if got := rr.Header().Get("Content-Language"); got != "fr" {
+		t.Errorf("got Content-Language %q; want %q", got, "fr")
+	}
+	if got := rr.Header().Values("Vary"); !slices.Contains(got, "Accept-Language") {
+		t.Errorf("got Vary %q; want it to include Accept-Language", got)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q; want %q", got, "application/problem+json")
+	}
+}
+
+func TestMetricMethod(t *testing.T) {
+	for method, want := range map[string]string{
+		http.MethodGet:    http.MethodGet,
+		http.MethodDelete: http.MethodDelete,
+		"PROPFIND":        "OTHER",
+		"get":             "OTHER",
+	} {
+		if got := metricMethod(method); got != want {
+			t.Errorf("metricMethod(%q) = %q; want %q", method, got, want)
+		}
+	}
+}
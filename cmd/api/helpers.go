@@ -7,13 +7,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/validator"
 	"github.com/julienschmidt/httprouter"
 
 	//Import the pq driver so that it can register itself with the database/sql
+	//package. The SQLite driver is registered by the data package
 	_ "github.com/lib/pq"
 )
 
@@ -37,9 +43,49 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// requestError explains why a request couldn't be understood. The message is in
+// English, while code and params pick out the matching message in the i18n catalogue,
+// so that badRequestResponse() can send the detail in the client's language.
+type requestError struct {
+	code    string
+	message string
+	params  map[string]string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// The readExpectedVersion() helper reads the X-Expected-Version request header, which
+// clients use to make an update conditional on the record's current version. The
+// value is parsed as an integer, so "3", "03" and " 3" all mean version 3. If the
+// header isn't present, ok is false; if it isn't a valid version, an error is returned.
+func (app *application) readExpectedVersion(r *http.Request) (version int32, ok bool, err error) {
+	s := strings.TrimSpace(r.Header.Get("X-Expected-Version"))
+	if s == "" {
+		return 0, false, nil
+	}
+
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || v < 0 {
+		return 0, false, &requestError{
+			code:    "invalid_expected_version",
+			message: "the X-Expected-Version header must be a non-negative integer",
+		}
+	}
+
+	return int32(v), true, nil
+}
+
 //For sending JSON responses to the client
 
 func (app *application) writeJson(data any, w http.ResponseWriter, r *http.Request) {
+	app.writeJsonStatus(data, w, r, http.StatusOK, nil)
+}
+
+// The writeJsonStatus() helper is like writeJson() but also lets the handler choose
+// the status code and add extra headers (such as Location) to the response.
+func (app *application) writeJsonStatus(data any, w http.ResponseWriter, r *http.Request, status int, headers http.Header) {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
 		http.NotFound(w, r)
@@ -47,10 +93,32 @@ func (app *application) writeJson(data any, w http.ResponseWriter, r *http.Reque
 	}
 	js = append(js, '\n')
 
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(js)
 }
 
+// The writeProblem() helper sends an RFC 7807 problem document to the client with
+// the given status code. Unlike writeJson() it returns any marshalling error, so the
+// caller can log it and fall back to an empty 500 response.
+func (app *application) writeProblem(w http.ResponseWriter, status int, p any) error {
+	js, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1,048,576
 	// bytes (1MB).
@@ -78,26 +146,49 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		// *json.SyntaxError. If it does, then return a plain-english error message
 		// which includes the location of the problem.
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return &requestError{
+				code:    "body_malformed_at",
+				message: fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset),
+				params:  map[string]string{"offset": strconv.FormatInt(syntaxError.Offset, 10)},
+			}
 		// In some circumstances Decode() may also return an io.ErrUnexpectedEOF error
 		// for syntax errors in the JSON. So we check for this using errors.Is() and
 		// return a generic error message.
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return &requestError{code: "body_malformed", message: "body contains badly-formed JSON"}
 		// Likewise, catch any *json.UnmarshalTypeError errors. These occur when the
 		// JSON value is the wrong type for the target destination. If the error relates
 		// to a specific field, then we include that in our error message to make it
 		// easier for the client to debug.
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return &requestError{
+					code:    "body_wrong_type_field",
+					message: fmt.Sprintf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field),
+					params:  map[string]string{"field": strconv.Quote(unmarshalTypeError.Field)},
+				}
+			}
+			return &requestError{
+				code:    "body_wrong_type_at",
+				message: fmt.Sprintf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset),
+				params:  map[string]string{"offset": strconv.FormatInt(unmarshalTypeError.Offset, 10)},
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 		// An io.EOF error will be returned by Decode() if the request body is empty. We
 		// check for this with errors.Is() and return a plain-english error message
 		// instead.
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return &requestError{code: "body_empty", message: "body must not be empty"}
+		// If the JSON contains a field which cannot be mapped to the target destination
+		// then Decode() will now return an error message in the format "json: unknown
+		// field "<name>"". We check for this, extract the field name from the error,
+		// and interpolate it into our custom error message.
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return &requestError{
+				code:    "body_unknown_key",
+				message: fmt.Sprintf("body contains unknown key %s", fieldName),
+				params:  map[string]string{"key": fieldName},
+			}
 			// A json.InvalidUnmarshalError error will be returned if we pass something
 			// that is not a non-nil pointer to Decode(). We catch this and panic,
 			// rather than returning an error to our handler.
@@ -106,7 +197,11 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 			// *http.MaxBytesError. If it does, then it means the request body exceeded our
 			// size limit of 1MB and we return a clear error message.
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return &requestError{
+				code:    "body_too_large",
+				message: fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit),
+				params:  map[string]string{"limit": strconv.FormatInt(maxBytesError.Limit, 10)},
+			}
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		// For anything else, return the error message as-is.
@@ -117,15 +212,93 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// The readString() helper returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	//Extract the value for a given key from the query string. If no key exists this
+	//will return the empty string ""
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// The readCSV() helper reads a string value from the query string and then splits it
+// into a slice on the comma character. If no matching key could be found, it returns
+// the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "integer", "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// The background() helper accepts an arbitrary function as a parameter and runs it
+// in a background goroutine. Any panic in the function is recovered and logged, so
+// that a failure in background work (such as sending an email) can't crash the server.
+// The goroutine is tracked by the application's WaitGroup, so that a graceful shutdown
+// waits for it to finish.
+func (app *application) background(fn func()) {
+	//Increment the WaitGroup counter
+	app.wg.Add(1)
+
+	go func() {
+		//Use defer to decrement the WaitGroup counter before the goroutine returns
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
 // The openDB() function returns a sql.DB connection pool
 // Uses db.PingContext() to actually create a connection and verify
 // that everything is set up correctly
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	driver, dsn := parseDSN(cfg.db.dsn)
+
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	//SQLite only allows one writer at a time, and an in-memory database exists only
+	//for the connection that created it, so we use a single connection for it
+	if driver == data.SQLiteDriver {
+		db.SetMaxOpenConns(1)
+	}
+
 	//Create a context with a 5-second timeout deadline
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -143,3 +316,68 @@ func openDB(cfg config) (*sql.DB, error) {
 	//Return the sql.DB connection pool
 	return db, nil
 }
+
+// The parseDSN() helper works out which database driver to use from the scheme of the
+// DSN. A DSN such as "sqlite://greenlight.db" or "sqlite3:///var/lib/greenlight.db"
+// selects the SQLite driver, and the rest of it is used as the database file path,
+// with foreign keys switched on and a busy timeout added so that concurrent writers
+// wait for each other instead of failing. Anything else is handed to the PostgreSQL
+// driver unchanged.
+func parseDSN(dsn string) (driver, source string) {
+	for _, scheme := range []string{"sqlite://", "sqlite3://"} {
+		if path, ok := strings.CutPrefix(dsn, scheme); ok {
+			separator := "?"
+			if strings.Contains(path, "?") {
+				separator = "&"
+			}
+			return data.SQLiteDriver, path + separator + "_foreign_keys=1&_busy_timeout=5000"
+		}
+	}
+
+	return "postgres", dsn
+}
+
+// The clientIP() helper returns the IP address of the client that made the request.
+// Normally this is the remote address of the connection, but if that address belongs
+// to one of our trusted proxies we walk the X-Forwarded-For header from right to left
+// and take the first address which isn't a trusted proxy. Entries further left than
+// that could have been set by the client itself, so they are never trusted.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !app.isTrustedProxy(ip) {
+			return ip
+		}
+		host = ip
+	}
+
+	return host
+}
+
+// isTrustedProxy reports whether the IP address is in one of the trusted proxy ranges.
+func (app *application) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range app.config.limiter.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
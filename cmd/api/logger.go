@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// contextHandler is a slog.Handler which adds the request ID stored in the context to
+// every log record, so that any app.logger call made with one of the *Context methods
+// (e.g. app.logger.ErrorContext(r.Context(), ...)) can be correlated with the request
+// that caused it.
+type contextHandler struct {
+	slog.Handler
+}
+
+// newContextHandler wraps a handler so that request IDs are added to its records.
+func newContextHandler(h slog.Handler) slog.Handler {
+	return contextHandler{Handler: h}
+}
+
+// Handle adds the request_id attribute, if there is one in the context, before passing
+// the record on to the wrapped handler.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := contextGetRequestInfo(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs and WithGroup keep the wrapper in place when the logger is extended with
+// logger.With() or logger.WithGroup().
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
-	"net/http"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/jwtauth"
+	"github.com/greenlight-api/internal/mailer"
+	"github.com/greenlight-api/internal/metrics"
+	"github.com/greenlight-api/internal/ratelimit"
 	"github.com/joho/godotenv"
 )
 
@@ -24,13 +32,43 @@ const version = "1.0.0"
 // application (development, staging, production, etc.). We will read in these
 // configuration settings from command-line flags when the application starts.
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	adminAddr       string
+	env             string
+	shutdownTimeout time.Duration
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		queryTimeout time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	auth struct {
+		mode string
+		jwt  struct {
+			issuer   string
+			audience string
+			keys     string
+		}
+	}
+	limiter struct {
+		rps            float64
+		burst          int
+		ipRPS          float64
+		ipBurst        int
+		enabled        bool
+		backend        string
+		trustedProxies []*net.IPNet
+	}
+	cors struct {
+		trustedOrigins []string
 	}
 }
 
@@ -38,9 +76,15 @@ type config struct {
 // and middleware. At the moment this only contains a copy of the config struct and a
 // logger, but it will grow to include a lot more as our build progresses.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config    config
+	logger    *slog.Logger
+	models    data.Models
+	mailer    mailer.Mailer
+	jwt       *jwtauth.Manager
+	limiter   ratelimit.Limiter
+	ipLimiter ratelimit.Limiter
+	metrics   *metrics.Metrics
+	wg        sync.WaitGroup
 }
 
 func main() {
@@ -62,62 +106,265 @@ func main() {
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
 
+	// Read the address of the admin listener, which serves the metrics at /metrics and
+	// /debug/vars. It is bound to localhost by default so that the metrics aren't
+	// public; an empty address turns the admin listener off
+	flag.StringVar(&cfg.adminAddr, "admin-addr", "localhost:4001", "Admin (metrics) server address")
+
+	// Read how long the server should wait for in-flight requests and background
+	// tasks to finish when it is asked to shut down
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Graceful shutdown deadline")
+
 	//Read the DSN value from the db-dsn command-line flag into the config struct.
-	//We default to using our development DSN if no flag is provided
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+	//We default to using our development DSN if no flag is provided. A DSN starting
+	//with sqlite:// (e.g. sqlite://greenlight.db) uses a SQLite database file instead
+	//of PostgreSQL
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN, or sqlite://<path> for SQLite")
 
 	// Read the connection pool settings from command-line flags into the config struct.
 	// Notice that the default values we're using are the ones we discussed above?
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+
+	// Read how long a single database query may run before it is canceled. Keeping
+	// this well below the server's WriteTimeout means a slow query gets a proper error
+	// response rather than a dropped connection.
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL query timeout")
+
+	// Read the SMTP server configuration settings into the config struct. If no
+	// host is given in the development environment, emails are logged instead of
+	// being sent; in any other environment the host is required. A local stand-in
+	// such as Mailpit can be used by pointing -smtp-host and -smtp-port at it and
+	// leaving the username empty.
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("GREENLIGHT_SMTP_HOST"), "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.example.com>", "SMTP sender")
+
+	// Read the authentication settings. In the default "token" mode, authentication
+	// tokens are random strings looked up in the tokens table on every request. In
+	// "jwt" mode the token endpoint issues signed JWTs which are verified without
+	// touching the tokens table. The keys are given as "kid:alg:base64" entries
+	// separated by commas (alg is HS256 or EdDSA); the first key signs new tokens.
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "token", "Authentication mode (token|jwt)")
+	flag.StringVar(&cfg.auth.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer (iss claim)")
+	flag.StringVar(&cfg.auth.jwt.audience, "jwt-audience", "greenlight-api", "JWT audience (aud claim)")
+	flag.StringVar(&cfg.auth.jwt.keys, "jwt-keys", os.Getenv("GREENLIGHT_JWT_KEYS"), "JWT signing keys (kid:alg:base64,...)")
+
+	// Read the rate limiter settings. Each client gets a token bucket which refills at
+	// -limiter-rps tokens per second and holds up to -limiter-burst tokens. Clients are
+	// identified by their user ID once authenticated, and by IP address otherwise. If
+	// the API runs behind a load balancer, list its addresses (or CIDR ranges) in
+	// -limiter-trusted-proxies so that the X-Forwarded-For header is used for them.
+	// Every IP address also gets a more generous bucket, set by -limiter-ip-rps and
+	// -limiter-ip-burst, which is checked before authentication so that guessing
+	// tokens and API keys is limited as well. The buckets are kept in memory by
+	// default; with -limiter-backend=postgres they are kept in the rate_limits table,
+	// so that they are shared by every API server using the database.
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.ipRPS, "limiter-ip-rps", 10, "Rate limiter maximum requests per second per IP address")
+	flag.IntVar(&cfg.limiter.ipBurst, "limiter-ip-burst", 20, "Rate limiter maximum burst per IP address")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Rate limiter backend (memory|postgres)")
+	flag.Func("limiter-trusted-proxies", "Trusted proxy IP addresses or CIDR ranges (comma separated)", func(val string) error {
+		proxies, err := parseTrustedProxies(val)
+		cfg.limiter.trustedProxies = proxies
+		return err
+	})
+
+	// Read the origins which browsers may call the API from, as a space-separated list
+	// such as "https://greenlight.example.com https://*.greenlight.example.com". A "*."
+	// prefix on the host matches any subdomain (but not the bare domain itself).
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
 	flag.Parse()
 
 	// Initialize a new structured logger which writes log entries to the standard out
-	// stream
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// stream. The context handler adds the request ID to entries logged with one of
+	// the *Context methods
+	logger := slog.New(newContextHandler(slog.NewTextHandler(os.Stdout, nil)))
+
+	//Run the application. Any error which stops it, whether at startup or during
+	//shutdown, is logged and the process exits with a non-zero status. run() returns
+	//first, so that its deferred cleanup has already happened by the time we exit
+	err = run(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// run() opens the database and the other dependencies, then serves the API until it
+// is shut down. The dependencies are released before it returns.
+func run(cfg config, logger *slog.Logger) error {
+	//Set up the JWT manager before connecting to the database, so that a bad key
+	//configuration is reported straight away
+	jwtManager, err := newJWTManager(cfg)
+	if err != nil {
+		return err
+	}
+
+	mail, err := newMailer(cfg, logger)
+	if err != nil {
+		return err
+	}
 
 	//Call the openDB() to create the connection pool passing in
 	//the config struct.
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Error(err.Error())
-		return
+		return err
 	}
 
-	//Defer a call to db.Close() so that the connection pool is closed
-	defer db.Close()
-
+	//Defer a call to db.Close() so that the connection pool is closed once the server
+	//has shut down and any background work has finished
+	defer func() {
+		db.Close()
+		logger.Info("database connection pool closed")
+	}()
 
 	logger.Info("database connection pool established")
 
+	limiter, err := newLimiter(cfg, db, cfg.limiter.rps, cfg.limiter.burst)
+	if err != nil {
+		return err
+	}
+
+	ipLimiter, err := newLimiter(cfg, db, cfg.limiter.ipRPS, cfg.limiter.ipBurst)
+	if err != nil {
+		return err
+	}
+
+	//Stop the limiters' background eviction once the server has shut down
+	defer func() {
+		if limiter != nil {
+			limiter.Stop()
+			ipLimiter.Stop()
+		}
+	}()
+
+	//Use the models which match the database the DSN points at. Both sets implement
+	//the same repository interfaces, so the handlers don't need to know which it is
+	models := data.NewModels(db, cfg.db.queryTimeout)
+	if driver, _ := parseDSN(cfg.db.dsn); driver == data.SQLiteDriver {
+		models = data.NewSQLiteModels(db, cfg.db.queryTimeout)
+	}
+
 	// Declare an instance of the application struct, containing the config
 	// struct and the logger
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db), //inject the models dependency
+		config:    cfg,
+		logger:    logger,
+		models:    models, //inject the models dependency
+		mailer:    mail,
+		jwt:       jwtManager,
+		limiter:   limiter,
+		ipLimiter: ipLimiter,
+		metrics:   newMetrics(db),
 	}
 
 	fmt.Println("env variable", cfg.db.dsn)
 
+	//Call app.serve() to start the server. It only returns once the server has been
+	//shut down, either because of an error or a SIGINT/SIGTERM signal
+	return app.serve()
+}
+
+// newMailer returns an SMTP mailer if an SMTP host has been configured. In the
+// development environment it falls back to an in-memory mailer which logs the emails
+// instead of sending them, but anywhere else a missing SMTP host is an error, since
+// users would never receive their activation and password reset tokens.
+func newMailer(cfg config, logger *slog.Logger) (mailer.Mailer, error) {
+	if cfg.smtp.host != "" {
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), nil
+	}
+
+	if cfg.env != "development" {
+		return nil, fmt.Errorf("an SMTP host must be configured with -smtp-host in the %s environment", cfg.env)
+	}
+
+	logger.Info("no SMTP host configured, emails will be logged instead of sent")
+	return mailer.NewMemory(100, logger), nil
+}
+
+// newJWTManager returns a JWT manager built from the configured keys when the "jwt"
+// authentication mode is selected, or nil in the default "token" mode.
+func newJWTManager(cfg config) (*jwtauth.Manager, error) {
+	switch cfg.auth.mode {
+	case "token":
+		return nil, nil
+	case "jwt":
+		keys, err := jwtauth.ParseKeys(cfg.auth.jwt.keys)
+		if err != nil {
+			return nil, err
+		}
+		return jwtauth.New(keys, cfg.auth.jwt.issuer, cfg.auth.jwt.audience)
+	default:
+		return nil, fmt.Errorf("invalid -auth-mode %q, must be token or jwt", cfg.auth.mode)
+	}
+}
 
-	//before the main() function exits
-	//Use the httprouter instance returned by app.routes() as the server handler
+// newLimiter returns a rate limiter with the given limits using the configured
+// backend, or nil if rate limiting is disabled. Clients are forgotten after three
+// minutes of inactivity.
+func newLimiter(cfg config, db *sql.DB, rps float64, burst int) (ratelimit.Limiter, error) {
+	if !cfg.limiter.enabled {
+		return nil, nil
+	}
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	if rps <= 0 || burst <= 0 {
+		return nil, errors.New("rate limiter requests per second and bursts must be greater than zero")
 	}
 
-	// Start the HTTP server
-	logger.Info("Starting server", "addr", server.Addr, "env", cfg.env)
+	switch cfg.limiter.backend {
+	case "memory":
+		return ratelimit.NewMemory(rps, burst, 3*time.Minute), nil
+	case "postgres":
+		if driver, _ := parseDSN(cfg.db.dsn); driver != "postgres" {
+			return nil, errors.New("-limiter-backend=postgres needs a PostgreSQL database")
+		}
+		return ratelimit.NewPostgres(db, rps, burst, 3*time.Minute, cfg.db.queryTimeout), nil
+	default:
+		return nil, fmt.Errorf("invalid -limiter-backend %q, must be memory or postgres", cfg.limiter.backend)
+	}
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+// Plain addresses are treated as ranges containing just that address.
+func parseTrustedProxies(val string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q", entry)
+		}
+
+		proxies = append(proxies, network)
+	}
 
-	err = server.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	return proxies, nil
 }
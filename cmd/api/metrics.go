@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/greenlight-api/internal/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The adminRoutes() method returns the handler for the admin listener, which serves
+// the application metrics. These aren't part of the public API: the expvar values
+// include details of the process and its database pool, so the admin listener is
+// bound to localhost by default (see the -admin-addr flag).
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	//Application metrics, as JSON from expvar and in the Prometheus text format
+	mux.HandleFunc("GET /debug/vars", app.expvarHandler)
+	mux.HandleFunc("GET /metrics", app.metricsHandler)
+
+	return mux
+}
+
+// The expvarHandler() writes the published expvar variables as a JSON object for the
+// "GET /debug/vars" endpoint. It does the same as expvar.Handler(), except that it
+// leaves out "cmdline", since the command-line flags include secrets such as the
+// database DSN, the SMTP password and the JWT signing keys.
+func (app *application) expvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			b.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(&b, "%q: %s", kv.Key, kv.Value)
+	})
+	b.WriteString("\n}\n")
+
+	_, err := io.WriteString(w, b.String())
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The metricsHandler() writes the application metrics in the Prometheus text format
+// for the "GET /metrics" endpoint.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := app.metrics.WritePrometheus(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// newMetrics creates the application metrics and registers gauges for the database
+// connection pool and the number of goroutines. It also publishes everything through
+// expvar, so the same values are available as JSON at "GET /debug/vars" on the admin
+// listener.
+func newMetrics(db *sql.DB) *metrics.Metrics {
+	m := metrics.New(version)
+
+	m.GaugeFunc("greenlight_goroutines", "Number of goroutines that currently exist", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	//The pool's wait and close counts only ever go up, so they are exposed as
+	//counters rather than gauges
+	dbStats := []struct {
+		name    string
+		help    string
+		counter bool
+		value   func(sql.DBStats) float64
+	}{
+		{"greenlight_db_max_open_connections", "Maximum number of open connections to the database", false, func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"greenlight_db_open_connections", "Number of established connections, both in use and idle", false, func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"greenlight_db_in_use_connections", "Number of connections currently in use", false, func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"greenlight_db_idle_connections", "Number of idle connections", false, func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"greenlight_db_wait_count_total", "Total number of connections waited for", true, func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"greenlight_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection", true, func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"greenlight_db_max_idle_closed_total", "Total number of connections closed due to the idle connection limit", true, func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"greenlight_db_max_idle_time_closed_total", "Total number of connections closed due to the idle time limit", true, func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"greenlight_db_max_lifetime_closed_total", "Total number of connections closed due to the connection lifetime limit", true, func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, stat := range dbStats {
+		fn := func() float64 { return stat.value(db.Stats()) }
+		if stat.counter {
+			m.CounterFunc(stat.name, stat.help, fn)
+		} else {
+			m.GaugeFunc(stat.name, stat.help, fn)
+		}
+	}
+
+	//Publish the values with expvar. The expvar package already publishes "cmdline"
+	//and "memstats", although expvarHandler() leaves "cmdline" out
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() any {
+		return time.Now().Unix()
+	}))
+
+	expvar.Publish("http", expvar.Func(m.Snapshot))
+
+	return m
+}
+
+// metricMethod returns the request method to label metrics with. Clients can send any
+// token as the method, so anything other than the standard methods is grouped under
+// "OTHER" to stop the number of distinct labels growing without bound.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// routePattern returns the pattern of the route which handles a request, such as
+// "/v1/movies/:id", for labelling metrics. Using the pattern rather than the URL path
+// keeps the number of distinct labels small. Requests which don't match a route are
+// grouped together under "unmatched".
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	//httprouter doesn't tell us which pattern matched, so rebuild it by putting each
+	//parameter's name back in place of the path segment holding its value. We work
+	//from the end of the path, since that's where parameters are in our routes, so a
+	//value which happens to equal an earlier literal segment isn't replaced instead
+	segments := strings.Split(r.URL.Path, "/")
+	i := len(segments) - 1
+	for j := len(params) - 1; j >= 0; j-- {
+		for ; i >= 0; i-- {
+			if segments[i] == params[j].Value {
+				segments[i] = ":" + params[j].Key
+				i--
+				break
+			}
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
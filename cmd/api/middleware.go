@@ -0,0 +1,601 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/ratelimit"
+	"github.com/greenlight-api/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The recoverPanic() middleware recovers from any panic in the handlers further down
+// the chain (such as the one readJSON() raises for an invalid decode target) and sends
+// a 500 Internal Server Error problem document, instead of letting net/http drop the
+// connection without a response.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Create a deferred function (which will always be run in the event of a panic
+		//as Go unwinds the stack).
+		defer func() {
+			//Use the builtin recover function to check if there has been a panic or not
+			pv := recover()
+			if pv == nil {
+				return
+			}
+
+			//http.ErrAbortHandler is used to deliberately abort a response, and
+			//net/http expects to see it again so it can suppress its own logging
+			if pv == http.ErrAbortHandler {
+				panic(pv)
+			}
+
+			//If there was a panic, set a "Connection: close" header on the response.
+			//This acts as a trigger to make Go's HTTP server automatically close the
+			//current connection after a response has been sent.
+			w.Header().Set("Connection", "close")
+
+			//Log the panic value and the stack trace so it can be debugged, then send
+			//the client the same body as serverErrorResponse()
+			app.logger.ErrorContext(r.Context(), fmt.Sprint(pv),
+				"method", r.Method,
+				"uri", r.URL.RequestURI(),
+				"stack", string(debug.Stack()),
+			)
+
+			message := "The server encountered a problem and could not process your request"
+			app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseWriter wraps an http.ResponseWriter to record the status code and the
+// number of bytes written, which the metrics middleware needs after the handler has
+// returned. Unwrap() lets http.ResponseController reach the underlying writer.
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.ResponseWriter.WriteHeader(statusCode)
+
+	if !rw.headerWritten {
+		rw.statusCode = statusCode
+		rw.headerWritten = true
+	}
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.headerWritten = true
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytesWritten += n
+	return n, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// The requestID() middleware gives every request an ID, which is stored in the request
+// context, echoed back in the X-Request-ID response header, and added to the log
+// entries and problem documents for the request. If the client (or a proxy in front
+// of us) sent a well-formed X-Request-ID header we reuse it, so that a request can be
+// traced across services; otherwise we generate a new random ID.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to reuse. We only
+// accept up to 128 letters, digits, hyphens, underscores and dots, so that it can't be
+// used to inject anything into our logs or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// The logRequest() middleware writes one access log line for every request once the
+// response has been sent, with the status code, the number of bytes in the body, how
+// long the request took, the ID of the authenticated user (0 for anonymous requests)
+// and the client's IP address.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		defer func() {
+			var userID int64
+			if info := contextGetRequestInfo(r.Context()); info != nil {
+				userID = info.userID
+			}
+
+			app.logger.InfoContext(r.Context(), "request completed",
+				"method", r.Method,
+				"uri", r.URL.RequestURI(),
+				"proto", r.Proto,
+				"status", rw.statusCode,
+				"bytes", rw.bytesWritten,
+				"duration", time.Since(start),
+				"user_id", userID,
+				"remote_ip", app.clientIP(r),
+			)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// The recordMetrics() middleware records every request and its response in app.metrics:
+// the request count, the response status code, and the processing time against the
+// pattern of the route which handled the request.
+func (app *application) recordMetrics(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.metrics.Start()
+
+		//Wrap the response writer, defaulting the status code to 200 OK since that
+		//is what gets sent if the handler never calls WriteHeader()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		//Record the response in a deferred function, so that it is counted even if
+		//the handler chain panics (for example with http.ErrAbortHandler)
+		defer func() {
+			app.metrics.Observe(metricMethod(r.Method), routePattern(router, r), rw.statusCode, time.Since(start))
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// The enableCORS() middleware lets browsers on trusted origins call the API. For a
+// request from a trusted origin it sets the Access-Control-Allow-Origin header, and if
+// the request is a CORS preflight request it responds straight away with the methods
+// and headers we accept.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Add the "Vary: Origin" header, since the response depends on the Origin
+		//header, and "Vary: Access-Control-Request-Method", since preflight responses
+		//depend on it too. Without these, a cache could serve one origin's response
+		//to another origin
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		//Get the value of the request's Origin header
+		origin := r.Header.Get("Origin")
+
+		//Only run this if there's an Origin request header present and it is one of
+		//our trusted origins
+		if origin != "" && app.isTrustedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			//Let the browser's JavaScript read the rate limit headers, which aren't
+			//in the CORS safelist of response headers
+			w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
+
+			//Check if the request has the HTTP method OPTIONS and contains the
+			//"Access-Control-Request-Method" header. If it does, then we treat it
+			//as a preflight request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				//Set the necessary preflight response headers
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Expected-Version")
+				w.Header().Set("Access-Control-Max-Age", "60")
+
+				//Write the headers along with a 200 OK status and return from the
+				//middleware with no further action
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isTrustedOrigin reports whether an Origin header value matches one of the trusted
+// origins. A trusted origin such as "https://*.example.com" matches any subdomain of
+// example.com over the same scheme, so long as the port matches too.
+func (app *application) isTrustedOrigin(origin string) bool {
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if origin == trusted {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(trusted, "://*.")
+		if !ok {
+			continue
+		}
+
+		rest, found := strings.CutPrefix(origin, scheme+"://")
+		if found && strings.HasSuffix(rest, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// The rateLimitIP() middleware applies a per-IP rate limit before the request is
+// authenticated. Without it, requests with a bad token or API key would be rejected
+// by authenticate() before any limit applied, so credentials could be guessed as fast
+// as the server could look them up. Its limits are more generous than the per-client
+// ones, since several users may share an address.
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Only carry out the check if rate limiting is enabled
+		if app.ipLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.allow(w, r, app.ipLimiter, "ip:"+app.clientIP(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// The rateLimit() middleware applies the per-client rate limit. Authenticated users are
+// limited by their user ID, so that several users behind one address don't share a
+// bucket, and anonymous clients are limited by IP address. Every response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers describing the
+// client's bucket, and requests over the limit get a 429 Too Many Requests response.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Only carry out the check if rate limiting is enabled
+		if app.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "anon:" + app.clientIP(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+		}
+
+		if app.allow(w, r, app.limiter, key) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// The allow() helper takes a token from the client's bucket in the given limiter and
+// sets the RateLimit-* headers. If the client is over the limit it sends a 429 Too
+// Many Requests response and returns false. If the limiter fails (e.g. because its
+// database is unavailable) the error is logged and the request is let through, so
+// that the limiter can't take the whole API down with it.
+func (app *application) allow(w http.ResponseWriter, r *http.Request, limiter ratelimit.Limiter, key string) bool {
+	result, err := limiter.Allow(r.Context(), key)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// ceilSeconds rounds a duration up to a whole number of seconds, as used by the
+// RateLimit-Reset and Retry-After headers.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// The authenticate() middleware resolves the bearer token in the Authorization header
+// to a user and stores it in the request context. Requests without an Authorization
+// header carry on as the AnonymousUser; requests with a malformed, unknown or expired
+// token get a 401 Unauthorized response. The bearer value can either be an
+// authentication token or a long-lived API key, which is recognised by its prefix.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Add the "Vary: Authorization" header to the response. This indicates to any
+		//caches that the response may vary based on the value of the Authorization
+		//header in the request.
+		w.Header().Add("Vary", "Authorization")
+
+		//Retrieve the value of the Authorization header from the request. This will
+		//return the empty string "" if there is no such header found.
+		authorizationHeader := r.Header.Get("Authorization")
+
+		//If there is no Authorization header found, use the contextSetUser() helper
+		//to add the AnonymousUser to the request context. Then we call the next
+		//handler in the chain and return without executing any of the code below.
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//Otherwise, we expect the value of the Authorization header to be in the format
+		//"Bearer <token>". We try to split this into its constituent parts, and if the
+		//header isn't in the expected format we return a 401 Unauthorized response
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		//API keys are handled separately, since they are looked up in a different
+		//table and carry their own permission scopes
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		//In JWT mode the token is verified from its signature and claims instead of
+		//being looked up in the tokens table
+		if app.jwt != nil {
+			app.authenticateJWT(w, r, next, token)
+			return
+		}
+
+		//Validate the token to make sure it is in a sensible format
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		//Retrieve the details of the user associated with the authentication token,
+		//again calling the invalidAuthenticationTokenResponse() helper if no
+		//matching record was found
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		//Call the contextSetUser() helper to add the user information to the request
+		//context
+		r = app.contextSetUser(r, user)
+
+		//Call the next handler in the chain
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey resolves an API key to its owner and stores both the user and the
+// key in the request context before calling the next handler.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	key, user, err := app.models.APIKeys.GetForKey(r.Context(), plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// authenticateJWT verifies a signed JWT and stores its user and claims in the request
+// context before calling the next handler. The user is built from the claims rather
+// than loaded from the database, so it only has the ID, version and activation status
+// filled in, and requirePermission() checks the permissions in the claims. This keeps
+// authentication off the database on every request, at the cost of tokens carrying
+// the user's details as they were when the token was issued. Endpoints where that
+// matters check the token against the current user record with requireFreshJWT().
+func (app *application) authenticateJWT(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil || userID < 1 {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user := &data.User{
+		ID:        userID,
+		Activated: claims.Activated,
+		Version:   claims.Version,
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetJWTClaims(r, claims)
+
+	next.ServeHTTP(w, r)
+}
+
+// The requireFreshJWT() middleware checks that a request authenticated with a JWT was
+// made by a user who still exists and whose version hasn't changed since the token
+// was issued. Since resetting a password bumps the version, this rejects every JWT
+// issued before the reset. Requests authenticated in any other way are let through,
+// since their credentials were already looked up in the database. It runs as part of
+// requireAuthenticatedUser(), so it covers every endpoint which needs a user.
+func (app *application) requireFreshJWT(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := app.contextGetJWTClaims(r)
+		if claims == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if user.Version != claims.Version {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The requireAuthenticatedUser() middleware checks that a user is not anonymous, and
+// that a JWT they authenticated with hasn't been superseded by a password reset.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	//Check the JWT against the user record once we know the request isn't anonymous,
+	//so that every middleware and handler after this one sees the current user
+	next = app.requireFreshJWT(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireActivatedUser() middleware checks that a user is both authenticated and
+// activated.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	//Rather than returning this http.HandlerFunc we assign it to the variable fn
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		//Check that a user is activated
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	//Wrap fn with the requireAuthenticatedUser() middleware before returning it
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The requireUserCredentials() middleware checks that a user is activated and that
+// the request wasn't authenticated with an API key. It protects the endpoints which
+// manage API keys, since otherwise a narrowly scoped key could be used to create a new
+// key with all of its owner's permissions, or to revoke the owner's other keys.
+func (app *application) requireUserCredentials(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
+// The requirePermission() middleware checks that the user is activated and holds the
+// given permission code (e.g. "movies:write"). The first parameter is the permission
+// code that we require the user to have.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		//Retrieve the user from the request context
+		user := app.contextGetUser(r)
+
+		//Get the slice of permissions for the user. A JWT carries the permissions the
+		//user had when it was issued, so we don't need to look them up
+		var permissions data.Permissions
+		if claims := app.contextGetJWTClaims(r); claims != nil {
+			permissions = claims.Permissions
+		} else {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		//Check if the slice includes the required permission. If it doesn't, then
+		//return a 403 Forbidden response
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		//If the request was made with an API key, the key must also have been granted
+		//the permission. Checking the user's permissions as well means that revoking
+		//a permission from a user also takes it away from all of their keys
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		//Otherwise they have the required permission so we call the next handler in
+		//the chain
+		next.ServeHTTP(w, r)
+	}
+
+	//Wrap this with the requireActivatedUser() middleware before returning it
+	return app.requireActivatedUser(fn)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	h := app.requestID(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})))
+
+	rr := send(t, h, http.MethodGet, "/v1/movies", "", "", "X-Request-ID", "req-1")
+	checkStatus(t, rr, http.StatusInternalServerError)
+
+	if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q; want application/problem+json", got)
+	}
+	if got := rr.Header().Get("Connection"); got != "close" {
+		t.Errorf("got Connection %q; want close", got)
+	}
+
+	var p problem
+	decode(t, rr, &p)
+	if p.Code != codeServerError || p.Status != http.StatusInternalServerError || p.RequestID != "req-1" || p.Instance != "/v1/movies" {
+		t.Errorf("got problem %+v", p)
+	}
+
+	//http.ErrAbortHandler is passed on to net/http, which expects to see it
+	h = app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if pv := recover(); pv != http.ErrAbortHandler {
+			t.Errorf("got panic %v; want http.ErrAbortHandler", pv)
+		}
+	}()
+	send(t, h, http.MethodGet, "/", "", "")
+}
+
+func TestCORS(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.cors.trustedOrigins = []string{"https://app.example.com", "https://*.example.org", "http://*.example.net:8080"}
+	h := app.routes()
+
+	tests := []struct {
+		origin  string
+		trusted bool
+	}{
+		{"https://app.example.com", true},
+		{"https://www.example.com", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"https://api.example.org.evil.com", false},
+		{"https://api.example.org:8443", false},
+		{"http://api.example.org", false},
+		{"http://api.example.net:8080", true},
+		{"http://api.example.net", false},
+		{"http://api.example.net:9090", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			rr := send(t, h, http.MethodOptions, "/v1/movies", "", "",
+				"Origin", tt.origin,
+				"Access-Control-Request-Method", http.MethodPut,
+			)
+
+			vary := rr.Header().Values("Vary")
+			if !strings.Contains(strings.Join(vary, ","), "Origin") {
+				t.Errorf("got Vary %q; want it to include Origin", vary)
+			}
+
+			if !tt.trusted {
+				if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("got Access-Control-Allow-Origin %q; want none", got)
+				}
+				if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "" {
+					t.Errorf("got Access-Control-Allow-Methods %q; want none", got)
+				}
+				return
+			}
+
+			checkStatus(t, rr, http.StatusOK)
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.origin {
+				t.Errorf("got Access-Control-Allow-Origin %q; want %q", got, tt.origin)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPut) {
+				t.Errorf("got Access-Control-Allow-Methods %q; want it to include PUT", got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+				t.Errorf("got Access-Control-Allow-Headers %q; want it to include Authorization", got)
+			}
+		})
+	}
+
+	//A simple request from a trusted origin gets the header as well, but isn't
+	//answered by the middleware
+	rr := send(t, h, http.MethodGet, "/v1/healthcheck", "", "", "Origin", "https://api.example.org")
+	checkStatus(t, rr, http.StatusOK)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://api.example.org" {
+		t.Errorf("got Access-Control-Allow-Origin %q on a GET", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("got Access-Control-Allow-Methods %q on a GET; want none", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app, _ := newTestApplication(t)
+	h := app.routes()
+
+	//A well-formed ID is echoed back and quoted in problem documents
+	rr := send(t, h, http.MethodGet, "/v1/nothing-here", "", "", "X-Request-ID", "trace-1.a_B")
+	checkStatus(t, rr, http.StatusNotFound)
+	if got := rr.Header().Get("X-Request-ID"); got != "trace-1.a_B" {
+		t.Errorf("got X-Request-ID %q; want trace-1.a_B", got)
+	}
+	var p problem
+	decode(t, rr, &p)
+	if p.RequestID != "trace-1.a_B" {
+		t.Errorf("got request_id %q; want trace-1.a_B", p.RequestID)
+	}
+
+	//Anything else is replaced with a new ID
+	for _, id := range []string{"", "has space", "line\nbreak", "<script>", strings.Repeat("a", 129)} {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+		r.Header["X-Request-Id"] = []string{id}
+		h.ServeHTTP(rr, r)
+
+		got := rr.Header().Get("X-Request-ID")
+		if got == id || !validRequestID(got) {
+			t.Errorf("got X-Request-ID %q for %q; want a new ID", got, id)
+		}
+	}
+
+	//Every request without an ID gets a different one
+	first := send(t, h, http.MethodGet, "/v1/healthcheck", "", "").Header().Get("X-Request-ID")
+	second := send(t, h, http.MethodGet, "/v1/healthcheck", "", "").Header().Get("X-Request-ID")
+	if first == second {
+		t.Errorf("got the same X-Request-ID %q for two requests", first)
+	}
+}
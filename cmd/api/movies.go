@@ -21,9 +21,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Version int32    `json:"version"`
 	}
 
+	//If readJSON() fails, send the client a 400 Bad Request response carrying
+	//the triage message explaining what was wrong with the body
 	err := app.readJSON(w, r, &input)
 	if err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
@@ -37,16 +39,19 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	v := validator.New()
 
+	//Use the Valid() method to see if any of the checks failed. If they did, then use
+	//the failedValidationResponse() helper to send a 422 response to the client,
+	//passing in the v.Errors map
 	data.ValidateMovie(v, movie)
 	if !v.Valid() {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	//Call in the Insert() method passing in a pointer to the validated movie struct
 	//This will create a record in the database and update the
 	//movie struct with the system-generated information
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -63,7 +68,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	//Write a JSON response with a 201 created status code, the movie data in the
 	//response body, and the Location header
 
-	app.writeJson(map[string]any{"movie": movie}, w, r)
+	app.writeJsonStatus(map[string]any{"movie": movie}, w, r, http.StatusCreated, headers)
 }
 
 // Add a showMovieHandler for the "GET /v1/movies/:id" endpoint
@@ -80,7 +85,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	//the errors.Is() function to check if it returns a data.ErrRecordNotFound error
 	//in which case we send a 404 not found response to the client
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -95,56 +100,227 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	app.writeJson(map[string]any{"movie": movie}, w, r)
 }
 
-func (app *application)updateMovieHandler(w http.ResponseWriter, r *http.Request){
-	//Extract the movie ID from the URL
-	id,err:=app.readIDParam(r)
-	if err !=nil{
-		app.notFoundResponse(w,r)
+// The updateMovieHandler serves "PUT /v1/movies/:id", which replaces the movie's
+// title, year, runtime and genres. Every field must be supplied: any that are left
+// out are zero values, which fail validation.
+func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	//Fetch the existing movie record, checking it against any X-Expected-Version
+	//header sent by the client
+	movie, ok := app.movieForUpdate(w, r)
+	if !ok {
+		return
+	}
+
+	//Declare an input struct to hold the expected data from the client
+	var input struct {
+		Title   string   `json:"title"`
+		Year    int32    `json:"year"`
+		Runtime int32    `json:"runtime"`
+		Genres  []string `json:"genres"`
+	}
+
+	//Read the JSON request body data into the input struct
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Copy the values from the request body to the appropriate fields of the movie
+	//record
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	app.saveMovie(w, r, movie)
+}
+
+// The patchMovieHandler serves "PATCH /v1/movies/:id". Any field left out of the
+// request body keeps its current value, so clients can send a partial update.
+func (app *application) patchMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForUpdate(w, r)
+	if !ok {
+		return
+	}
+
+	//Declare an input struct to hold the expected data from the client. We use
+	//pointers for the title, year and runtime fields so that we can tell a field
+	//that was left out of the JSON (nil) apart from one set to its zero value.
+	//Slices already have a nil zero value, so genres doesn't need a pointer.
+	var input struct {
+		Title   *string  `json:"title"`
+		Year    *int32   `json:"year"`
+		Runtime *int32   `json:"runtime"`
+		Genres  []string `json:"genres"`
+	}
+
+	//Read the JSON request body data into the input struct
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//Fetch the existing movie record from the database, sending a 404 Not Found 
+	//Only copy the values that were supplied in the request body to the
+	//appropriate fields of the movie record, leaving the others unchanged
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	app.saveMovie(w, r, movie)
+}
+
+// The movieForUpdate() helper fetches the movie named by the "id" URL parameter for
+// the PUT and PATCH handlers. If the request has an X-Expected-Version header, the
+// movie's current version must match it. When the movie can't be used, the error
+// response has already been sent and ok is false.
+func (app *application) movieForUpdate(w http.ResponseWriter, r *http.Request) (movie *data.Movie, ok bool) {
+	//Extract the movie ID from the URL
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	//Read the expected version before touching the database, so that a malformed
+	//header gets a 400 Bad Request response
+	expected, hasExpected, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	//Fetch the existing movie record from the database, sending a 404 Not Found
 	//response to the client if we couldn't find a matching record
-	movie,err:=app.models.Movies.Get(id)
-	if err !=nil{
+	movie, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w,r)
+			app.notFoundResponse(w, r)
 		default:
-			app.serverErrorResponse(w,r,err)
+			app.serverErrorResponse(w, r, err)
 		}
-		return
+		return nil, false
 	}
 
-	//Declare an input struct to hold the expected data from the client
-	var input struct{
-		Title string `json:"title"`
-		Year int32 `json:"year"`
-		Runtime int32 `json:"runtime"`
-		Genres  []string  `json:"genres"`
+	//If the client told us which version it expects, send a 409 Conflict response
+	//when the movie has changed since then
+	if hasExpected && movie.Version != expected {
+		app.editConflictResponse(w, r)
+		return nil, false
 	}
 
-	//Read the JSON request body data into the input struct
-	app.readJSON(w,r,&input)
-
-	//Copy the values from the request body to the appropriate fields of the 
-	//movie record
-	movie.Title=input.Title
-	movie.Year=input.Year
-	movie.Runtime=input.Runtime
-	movie.Genres=input.Genres
+	return movie, true
+}
 
-	//Validate the updated movie record, sending the client a 422 unprocessable Entity 
+// The saveMovie() helper validates an updated movie record and saves it, then sends
+// the updated movie to the client.
+func (app *application) saveMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
+	//Validate the updated movie record, sending the client a 422 unprocessable Entity
 	//Entity response if any checks fail
-	v:=validator.New()
+	v := validator.New()
 
-	data.ValidateMovie(v,movie)
+	data.ValidateMovie(v, movie)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	if !v.Valid(){
-		app.serverErrorResponse(w,r,err)
+	//Pass the updated movie record to our Update() method. If the version has
+	//changed since we fetched the movie above, send the client a 409 Conflict
+	//response instead of overwriting the other change
+	err := app.models.Movies.Update(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	//Pass the updated movie record in a JSON response
-	app.writeJson(map[string]any{"movie":movie},w,r)
+	app.writeJson(map[string]any{"movie": movie}, w, r)
+}
+
+// Add a deleteMovieHandler for the "DELETE /v1/movies/:id" endpoint
+func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	//Extract the movie ID from the URL
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Delete the movie from the database, sending a 404 Not Found response to the
+	//client if there isn't a matching record
+	err = app.models.Movies.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Return a 200 OK status code along with a success message
+	app.writeJson(map[string]any{"message": "movie successfully deleted"}, w, r)
+}
+
+// Add a listMoviesHandler for the "GET /v1/movies" endpoint. The title, genres,
+// page, page_size and sort values are all read from the query string.
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	//Call r.URL.Query() to get the url.Values map containing the query string data
+	qs := r.URL.Query()
+
+	//Use our helpers to extract the title and genres query string values, falling
+	//back to defaults of an empty string and an empty slice respectively
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+
+	//Get the page and page_size query string values as integers, defaulting to
+	//page 1 and a page size of 20
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	//Extract the sort query string value, falling back to "id" if it is not provided
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	//Only allow sorting on these columns, ascending or descending (hyphen prefix)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(map[string]any{"movies": movies, "metadata": metadata}, w, r)
 }
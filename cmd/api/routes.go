@@ -13,17 +13,55 @@ func (app *application) routes() http.Handler {
 	//Initialize a new httprouter router instance
 	router := httprouter.New()
 
+	//Convert the notFoundResponse() and methodNotAllowedResponse() helpers to the
+	//http.Handler type and set them as the custom error handlers for 404 and 405
+	//responses, so the router's own errors are also sent as problem documents
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
 	//Register the relevant methods, URL patterns and handler functions
 	//for our endpoints using the HandlerFunc() method.
 	//http.MethodGet and http.MethodPost are constants which equate to the
 	//strings "GET" and "POST" respectively
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
+
+	//Browsing the catalogue needs the "movies:read" permission, and anything which
+	//changes it needs "movies:write"
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
 	// Add the route for the PUT /v1/movies/:id endpoint.
-	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.updateMovieHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	// The PATCH route only applies the fields supplied, unlike PUT which replaces them all.
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.patchMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	//API keys can be managed by any activated user, but not by using another API key
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireUserCredentials(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireUserCredentials(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireUserCredentials(app.deleteAPIKeyHandler))
 
-	//Return the httprouter instance
-	return router
+	//Wrap the router with the authenticate() middleware so that every request has a
+	//user (possibly the AnonymousUser) in its context, and wrap everything in the
+	//recoverPanic() middleware so that a panic anywhere still gets a JSON response.
+	//The rateLimit() middleware runs after authenticate(), so that it can limit
+	//authenticated users by their ID rather than their IP address, while rateLimitIP()
+	//runs before it, so that failed authentication attempts are limited too.
+	//enableCORS() runs before all of them, so that preflight requests are answered
+	//without using up the client's allowance and error responses still carry the CORS
+	//headers
+	//The recordMetrics() middleware wraps everything else, so that every response is
+	//counted, including those sent by the other middleware. Next come requestID() and
+	//logRequest(), so that every log entry and problem document (including those for
+	//panics) carries the request ID, and every response gets an access log line
+	return app.recordMetrics(router, app.requestID(app.logRequest(app.recoverPanic(app.enableCORS(app.rateLimitIP(app.authenticate(app.rateLimit(router))))))))
 }
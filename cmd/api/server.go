@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method starts the HTTP server and blocks until it has been shut down.
+// When the process receives a SIGINT or SIGTERM signal, the server stops accepting new
+// connections and is given until the configured shutdown timeout to finish in-flight
+// requests and any background tasks (such as sending emails) before serve() returns.
+// If an admin address is configured, the admin server which serves the metrics runs
+// alongside the API server and is shut down with it.
+func (app *application) serve() error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	//Bind the admin listener up front, so that a bad address or a port which is
+	//already in use stops the application from starting
+	var admin *http.Server
+	if app.config.adminAddr != "" {
+		admin = &http.Server{
+			Addr:         app.config.adminAddr,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+
+		ln, err := net.Listen("tcp", admin.Addr)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			app.logger.Info("starting admin server", "addr", admin.Addr)
+
+			err := admin.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error(err.Error(), "addr", admin.Addr)
+			}
+		}()
+	}
+
+	//Create a shutdownError channel. We will use this to receive any errors returned
+	//by the graceful Shutdown() function
+	shutdownError := make(chan error)
+
+	//Start a background goroutine which waits for a shutdown signal
+	go func() {
+		//Create a quit channel which carries os.Signal values, and use signal.Notify()
+		//to listen for incoming SIGINT and SIGTERM signals and relay them to it
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		//Read the signal from the quit channel. This code will block until a signal
+		//is received
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		//Create a context with the configured timeout for the HTTP server
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		//Call Shutdown() on the server. It stops accepting new connections and waits
+		//for in-flight requests to complete, returning an error if the context
+		//deadline passes first. In that case we close the remaining connections, but
+		//still wait for the background tasks below so that they aren't cut off by the
+		//database connection pool being closed
+		err := server.Shutdown(ctx)
+		if err != nil {
+			server.Close()
+		}
+
+		//The admin server only serves the metrics, so there is nothing to wait for
+		if admin != nil {
+			admin.Close()
+		}
+
+		app.logger.Info("completing background tasks", "addr", server.Addr)
+
+		//Wait for the background goroutines to finish. This gets its own deadline,
+		//since ctx has already expired if Shutdown() ran out of time
+		if !app.waitForBackgroundTasks(app.config.shutdownTimeout) {
+			err = errors.Join(err, errors.New("timed out waiting for background tasks to complete"))
+		}
+
+		shutdownError <- err
+	}()
+
+	app.logger.Info("starting server", "addr", server.Addr, "env", app.config.env)
+
+	//Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	//return a http.ErrServerClosed error. So if we see this error, it is actually a
+	//good thing and an indication that the graceful shutdown has started. So we only
+	//return the error if it is NOT http.ErrServerClosed
+	err := server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		if admin != nil {
+			admin.Close()
+		}
+		return err
+	}
+
+	//Otherwise, we wait to receive the return value from Shutdown() on the
+	//shutdownError channel. If the return value is an error, we know that there was
+	//a problem with the graceful shutdown and we return the error
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", server.Addr)
+
+	return nil
+}
+
+// waitForBackgroundTasks waits for the goroutines started by background() to finish,
+// for up to timeout. It reports whether they all finished in time.
+func (app *application) waitForBackgroundTasks(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
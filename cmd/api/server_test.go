@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitForBackgroundTasks(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	if !app.waitForBackgroundTasks(time.Second) {
+		t.Error("got a timeout with no background tasks running")
+	}
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	app.background(func() {
+		<-release
+		close(finished)
+	})
+
+	if app.waitForBackgroundTasks(10 * time.Millisecond) {
+		t.Fatal("got no timeout while a background task was still running")
+	}
+
+	//Each wait has its own deadline, so a task which finishes after an earlier wait
+	//gave up is still waited for
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+
+	if !app.waitForBackgroundTasks(time.Second) {
+		t.Fatal("got a timeout; want the background task to be waited for")
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("returned before the background task finished")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/internal/jwtauth"
+	"github.com/greenlight-api/validator"
+)
+
+// Add a createAuthenticationTokenHandler for the "POST /v1/tokens/authentication"
+// endpoint. It exchanges a user's email address and password for a token which is
+// valid for 24 hours.
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse the email and password from the request body
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Validate the email and password provided by the client
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Lookup the user record based on the email address. If no matching user was
+	//found, then we call the invalidCredentialsResponse() helper to send a 401
+	//Unauthorized response to the client
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Check if the provided password matches the actual password for the user
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//If the passwords don't match, then we call the invalidCredentialsResponse()
+	//helper again and return
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	//Otherwise, if the password is correct, we generate a new token with a 24-hour
+	//expiry time and the scope 'authentication'. In JWT mode the token is a signed
+	//JWT instead, but it is sent to the client in exactly the same shape
+	var token *data.Token
+
+	if app.jwt != nil {
+		token, err = app.newJWT(r.Context(), user)
+	} else {
+		token, err = app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Encode the token to JSON and send it in the response along with a 201 Created
+	//status code
+	app.writeJsonStatus(map[string]any{"authentication_token": token}, w, r, http.StatusCreated, nil)
+}
+
+// newJWT signs a JWT for the user with a 24-hour expiry time and wraps it in a Token
+// struct, so the response looks the same as in the default token mode. The user's
+// activation status and permissions are included in the token, so that requests made
+// with it can be authorized without going to the database.
+func (app *application) newJWT(ctx context.Context, user *data.User) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, expiry, err := app.jwt.Issue(jwtauth.Subject{
+		UserID:      user.ID,
+		Version:     user.Version,
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
+// Add a deleteAuthenticationTokenHandler for the "DELETE /v1/tokens/authentication"
+// endpoint. It logs the user out by revoking the token the request was made with. In
+// JWT mode the token's ID is added to the revocation list until the token expires.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//API keys aren't session tokens, so they have to be revoked through their own
+	//endpoint
+	if app.contextGetAPIKey(r) != nil {
+		app.badRequestResponse(w, r, &requestError{
+			code:    "api_key_logout",
+			message: "API keys must be revoked with DELETE /v1/api-keys/:id",
+		})
+		return
+	}
+
+	if claims := app.contextGetJWTClaims(r); claims != nil {
+		app.jwt.Revoke(claims)
+	} else {
+		//The authenticate() middleware has already checked the header is in the
+		//"Bearer <token>" format, so we can safely take the token from it
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		err := app.models.Tokens.DeleteForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJson(map[string]any{"message": "you have been logged out"}, w, r)
+}
+
+// Add a createPasswordResetTokenHandler for the "POST /v1/tokens/password-reset"
+// endpoint. It emails the user a short-lived token which can be exchanged for a new
+// password at "PUT /v1/users/password".
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse and validate the user's email address
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Try to retrieve the corresponding user record for the email address. Whether or
+	//not there is one, and whether or not it has been activated, the client gets the
+	//same response, so that this endpoint can't be used to find out which email
+	//addresses are registered. The email is only sent to an activated user
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil && user.Activated:
+		//Create a new password reset token with a 45-minute expiry time
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		//Email the user with their password reset token
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			//Since email addresses MAY be case sensitive, notice that we are sending this
+			//email using the address stored in our database for the user --- not to the
+			//input.Email address provided by the client in this request
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.ErrorContext(r.Context(), err.Error())
+			}
+		})
+	case err == nil, errors.Is(err, data.ErrRecordNotFound):
+		//Unknown or inactive account, there's nothing to send
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send a 202 Accepted response and confirmation message to the client
+	env := map[string]any{"message": "an email will be sent to you containing password reset instructions"}
+
+	app.writeJsonStatus(env, w, r, http.StatusAccepted, nil)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/greenlight-api/internal/data"
+	"github.com/greenlight-api/validator"
+)
+
+// Add a registerUserHandler for the "POST /v1/users" endpoint
+func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	//Create an anonymous struct to hold the expected data from the request body
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	//Parse the request body into the anonymous struct
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Copy the data from the request body into a new User struct. Notice also that we
+	//set the Activated field to false, which isn't strictly necessary because the
+	//Activated field will have the zero-value of false by default. But setting this
+	//explicitly helps to make our intentions clear to anyone reading the code.
+	user := &data.User{
+		Name:      input.Name,
+		Email:     input.Email,
+		Activated: false,
+	}
+
+	v := validator.New()
+
+	//Check the plaintext password before hashing it. bcrypt can't hash a password
+	//longer than 72 bytes, and there's no point spending time on the hash for a
+	//request which is going to be rejected anyway
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Use the Password.Set() method to generate and store the hashed and plaintext
+	//passwords
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Validate the user struct and return the error messages to the client if any of
+	//the checks fail
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Insert the user data into the database
+	err = app.models.Users.Insert(r.Context(), user)
+	if err != nil {
+		switch {
+		//If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
+		//add a message to the validator instance, and then call our
+		//failedValidationResponse() helper
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "duplicate", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Add the "movies:read" permission for the new user. Write access is granted to
+	//curators separately
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//After the user record has been created in the database, generate a new
+	//activation token for the user
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send the welcome email in a background goroutine so the client doesn't have to
+	//wait for the SMTP round trip. The data map holds the plaintext activation token
+	//and the user ID for the template.
+	app.background(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+	})
+
+	//Write a JSON response containing the user data along with a 201 Created status
+	//code
+	app.writeJsonStatus(map[string]any{"user": user}, w, r, http.StatusCreated, nil)
+}
+
+// Add an activateUserHandler for the "PUT /v1/users/activated" endpoint
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse the plaintext activation token from the request body
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Validate the plaintext token provided by the client
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Retrieve the details of the user associated with the token using the
+	//GetForToken() method. If no matching record is found, then we let the client
+	//know that the token they provided is not valid
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid_token", "invalid or expired activation token", "kind", data.ScopeActivation)
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Update the user's activation status
+	user.Activated = true
+
+	//Save the updated user record in our database, checking for any edit conflicts in
+	//the same way that we did for our movie records
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//If everything went successfully, then we delete all activation tokens for the
+	//user
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send the updated user details to the client in a JSON response
+	app.writeJson(map[string]any{"user": user}, w, r)
+}
+
+// Add an updateUserPasswordHandler for the "PUT /v1/users/password" endpoint
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	//Parse and validate the user's new password and password reset token
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Retrieve the details of the user associated with the password reset token,
+	//returning an error message if no matching record was found
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid_token", "invalid or expired password reset token", "kind", data.ScopePasswordReset)
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Set the new password for the user
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Save the updated user record in our database, checking for any edit conflicts as
+	//normal. Update() also bumps the user's version number
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//If everything was successful, then delete all of the user's tokens. This makes
+	//the password reset token single-use, and also revokes any authentication tokens
+	//issued under the old password
+	err = app.models.Tokens.DeleteAllScopesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//API keys were also issued under the old password, so revoke those too in case
+	//they were created by whoever had access to the account
+	err = app.models.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send the user a confirmation message
+	env := map[string]any{"message": "your password was successfully reset"}
+
+	app.writeJson(env, w, r)
+}
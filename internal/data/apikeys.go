@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/greenlight-api/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix is prepended to every API key so that keys are easy to recognise in
+// configuration files and secret scanners, and so that the authenticate middleware
+// can tell them apart from short-lived authentication tokens.
+const APIKeyPrefix = "glk_"
+
+// APIKey is a long-lived credential for service-to-service clients such as batch jobs
+// and partner integrations. Like tokens, only the SHA-256 hash of the key is stored;
+// the plaintext is returned to the client once, when the key is created. Prefix holds
+// the first few characters of the key so that users can tell their keys apart.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Label       string      `json:"label" validate:"required,max=100"`
+	Plaintext   string      `json:"key,omitzero" validate:"-"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions" validate:"min=1,unique"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Expiry      *time.Time  `json:"expiry" validate:"future"`
+}
+
+func init() {
+	validator.RegisterRule("future", validator.Rule{
+		Check: func(field reflect.Value, _ string) bool {
+			expiry, ok := field.Interface().(time.Time)
+			return ok && expiry.After(time.Now())
+		},
+		Message: func(reflect.Value, string) string {
+			return "must be in the future"
+		},
+	})
+
+	//The permissions a key may be given depend on its owner, so the rule takes them
+	//as a space-separated parameter, e.g. "not_granted=movies:read movies:write"
+	validator.RegisterRule("not_granted", validator.Rule{
+		Check: func(field reflect.Value, param string) bool {
+			return validator.PermittedValue(field.String(), strings.Fields(param)...)
+		},
+		Message: func(reflect.Value, string) string {
+			return "must be a permission you hold"
+		},
+	})
+
+	validator.RegisterRule("invalid_api_key", validator.Rule{
+		Check: func(field reflect.Value, _ string) bool {
+			return strings.HasPrefix(field.String(), APIKeyPrefix)
+		},
+		Message: func(reflect.Value, string) string {
+			return "must be a valid API key"
+		},
+	})
+}
+
+// generateAPIKey creates a new random API key for a user.
+func generateAPIKey(userID int64, label string, permissions Permissions, expiry *time.Time) *APIKey {
+	plaintext := APIKeyPrefix + rand.Text()
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return &APIKey{
+		UserID:      userID,
+		Label:       label,
+		Plaintext:   plaintext,
+		Prefix:      plaintext[:len(APIKeyPrefix)+4],
+		Hash:        hash[:],
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+}
+
+// ValidateAPIKeyPlaintext checks that a plaintext API key has the right prefix and
+// length.
+func ValidateAPIKeyPlaintext(v *validator.Validator, key string) {
+	v.Var(key, "key", "required,invalid_api_key,len=30")
+}
+
+// ValidateAPIKey checks the label, permission scopes and expiry of a new API key. The
+// granted argument holds the permissions of the user creating the key, since a key
+// can't be given more access than its owner has.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Struct(key)
+	v.Var(key.Permissions, "permissions", "dive,not_granted="+strings.Join(granted, " "))
+}
+
+// APIKeyModel struct type that wraps a sql.DB connection pool
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// The New() method is a shortcut which generates a new API key and then inserts it
+// into the api_keys table. The returned key is the only place the plaintext appears.
+func (m APIKeyModel) New(ctx context.Context, userID int64, label string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := generateAPIKey(userID, label, permissions, expiry)
+
+	err := m.Insert(ctx, key)
+	return key, err
+}
+
+// Insert adds a new API key to the api_keys table, reading the generated id and
+// created_at values back into the struct.
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO api_keys (user_id, label, prefix, hash, permissions, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	args := []any{key.UserID, key.Label, key.Prefix, key.Hash, pq.Array(key.Permissions), key.Expiry}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	return queryError(ctx, err)
+}
+
+// GetAllForUser returns the API keys belonging to a user, newest first.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, label, prefix, permissions, created_at, last_used_at, expiry
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Label,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+// Delete removes one of a user's API keys. The user_id condition stops one user from
+// deleting another user's keys by guessing IDs.
+func (m APIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser revokes every API key belonging to a user, e.g. when their
+// password is reset.
+func (m APIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	DELETE FROM api_keys
+	WHERE user_id = $1
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return queryError(ctx, err)
+}
+
+// GetForKey looks up an unexpired API key from its plaintext, records that it has
+// just been used, and returns it along with the user who owns it.
+func (m APIKeyModel) GetForKey(ctx context.Context, plaintext string) (*APIKey, *User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	//The UPDATE in the common table expression stamps last_used_at and hands the key
+	//row to the outer SELECT, which joins it to its owner, all in one round trip
+	query := `
+	WITH key AS (
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND (expiry IS NULL OR expiry > NOW())
+		RETURNING id, user_id, label, prefix, permissions, created_at, last_used_at, expiry
+	)
+	SELECT key.id, key.label, key.prefix, key.permissions, key.created_at, key.last_used_at, key.expiry,
+		users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM key
+	INNER JOIN users ON users.id = key.user_id
+	`
+
+	var key APIKey
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID,
+		&key.Label,
+		&key.Prefix,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, queryError(ctx, err)
+		}
+	}
+
+	key.UserID = user.ID
+
+	return &key, &user, nil
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/greenlight-api/validator"
+)
+
+func TestValidateAPIKey(t *testing.T) {
+	granted := Permissions{"movies:read", "movies:write"}
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		key  APIKey
+		want map[string][]string
+	}{
+		{
+			name: "valid",
+			key:  APIKey{Label: "ci", Permissions: Permissions{"movies:read"}, Expiry: &future},
+			want: map[string][]string{},
+		},
+		{
+			name: "without an expiry",
+			key:  APIKey{Label: "ci", Permissions: Permissions{"movies:read", "movies:write"}},
+			want: map[string][]string{},
+		},
+		{
+			name: "missing fields",
+			key:  APIKey{},
+			want: map[string][]string{"label": {"required"}, "permissions": {"min"}},
+		},
+		{
+			name: "invalid fields",
+			key: APIKey{
+				Label:       string(make([]byte, 101)),
+				Permissions: Permissions{"movies:read", "movies:delete", "movies:read"},
+				Expiry:      &past,
+			},
+			want: map[string][]string{
+				"label":         {"max"},
+				"permissions":   {"unique"},
+				"permissions/1": {"not_granted"},
+				"expiry":        {"future"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateAPIKey(v, &tt.key, granted)
+
+			if got := errorCodes(v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	//A user without any permissions can't give a key any
+	v := validator.New()
+	ValidateAPIKey(v, &APIKey{Label: "ci", Permissions: Permissions{"movies:read"}}, nil)
+	if got := errorCodes(v); !reflect.DeepEqual(got, map[string][]string{"permissions/0": {"not_granted"}}) {
+		t.Errorf("got errors %v for a user without permissions", got)
+	}
+}
+
+func TestValidateAPIKeyPlaintext(t *testing.T) {
+	tests := []struct {
+		key  string
+		want map[string][]string
+	}{
+		{APIKeyPrefix + "ABCDEFGHIJKLMNOPQRSTUVWXYZ", map[string][]string{}},
+		{"", map[string][]string{"key": {"required", "invalid_api_key", "len"}}},
+		{"xyz_ABCDEFGHIJKLMNOPQRSTUVWXYZ", map[string][]string{"key": {"invalid_api_key"}}},
+		{APIKeyPrefix + "ABC", map[string][]string{"key": {"len"}}},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateAPIKeyPlaintext(v, tt.key)
+
+		if got := errorCodes(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got errors %v; want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"math"
+	"strings"
+
+	"github.com/greenlight-api/validator"
+)
+
+// Filters holds the pagination and sorting values taken from the query string.
+// SortSafelist holds the sort values that the client is allowed to use, so we
+// never interpolate untrusted input into the ORDER BY clause of a query. The json
+// tags name the query string parameters, which validation errors are keyed by.
+type Filters struct {
+	Page         int      `json:"page" validate:"min=1,max=10000000"`
+	PageSize     int      `json:"page_size" validate:"min=1,max=100"`
+	Sort         string   `json:"sort"`
+	SortSafelist []string `json:"-"`
+}
+
+// ValidateFilters checks that the page and page_size parameters are within a
+// sensible range and that the sort parameter matches a value in the safelist.
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Struct(f)
+	v.Var(f.Sort, "sort", "oneof="+strings.Join(f.SortSafelist, " "))
+}
+
+// sortColumn checks that the client-provided Sort field matches one of the entries
+// in our safelist and if it does, extracts the column name from the Sort field by
+// stripping the leading hyphen character (if one exists).
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	//ValidateFilters() should already have rejected anything outside the
+	//safelist, so reaching this point means a handler forgot to validate.
+	//Panic rather than risk an SQL injection attack.
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection returns the sort direction ("ASC" or "DESC") depending on the
+// prefix character of the Sort field.
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata holds the pagination metadata which is sent alongside a list of records.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitzero"`
+	PageSize     int `json:"page_size,omitzero"`
+	FirstPage    int `json:"first_page,omitzero"`
+	LastPage     int `json:"last_page,omitzero"`
+	TotalRecords int `json:"total_records,omitzero"`
+}
+
+// calculateMetadata works out the pagination metadata values given the total
+// number of records, the current page and the page size. If there are no records
+// we return an empty Metadata struct, which the omitzero tags render as {}.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
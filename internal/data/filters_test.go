@@ -0,0 +1,71 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/greenlight-api/validator"
+)
+
+// errorCodes returns the error codes recorded for each key.
+func errorCodes(v *validator.Validator) map[string][]string {
+	got := make(map[string][]string)
+	for key, fieldErrors := range v.Errors {
+		for _, fe := range fieldErrors {
+			got[key] = append(got[key], fe.Code)
+		}
+	}
+	return got
+}
+
+func TestValidateFilters(t *testing.T) {
+	safelist := []string{"id", "title", "-id", "-title"}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		want    map[string][]string
+	}{
+		{
+			name:    "valid",
+			filters: Filters{Page: 1, PageSize: 20, Sort: "-title"},
+			want:    map[string][]string{},
+		},
+		{
+			name:    "too small",
+			filters: Filters{Page: 0, PageSize: 0, Sort: "id"},
+			want:    map[string][]string{"page": {"min"}, "page_size": {"min"}},
+		},
+		{
+			name:    "too big",
+			filters: Filters{Page: 10_000_001, PageSize: 101, Sort: "year"},
+			want:    map[string][]string{"page": {"max"}, "page_size": {"max"}, "sort": {"oneof"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortSafelist = safelist
+
+			v := validator.New()
+			ValidateFilters(v, tt.filters)
+
+			if got := errorCodes(v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFiltersMessages(t *testing.T) {
+	v := validator.New()
+	ValidateFilters(v, Filters{Page: 1, PageSize: 101, Sort: "year", SortSafelist: []string{"id", "-id"}})
+
+	want := map[string][]validator.FieldError{
+		"page_size": {{Code: "max", Message: "must not be greater than 100", Params: map[string]string{"max": "100"}}},
+		"sort":      {{Code: "oneof", Message: "must be one of: id, -id", Params: map[string]string{"values": "id, -id", "kind": "string"}}},
+	}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got %v; want %v", v.Errors, want)
+	}
+}
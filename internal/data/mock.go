@@ -0,0 +1,450 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// mockStore holds the records for the in-memory repositories returned by
+// NewMockModels(). The repositories share one store (and one mutex) since, like the
+// tables they stand in for, some of them read each other's records.
+type mockStore struct {
+	mu sync.Mutex
+
+	movies      map[int64]Movie
+	users       map[int64]User
+	tokens      []Token
+	permissions map[int64]Permissions
+	apiKeys     map[int64]APIKey
+
+	lastMovieID  int64
+	lastUserID   int64
+	lastAPIKeyID int64
+}
+
+// mockPermissionCodes are the permission codes seeded by the migrations. As with the
+// permissions table, AddForUser() ignores any other codes.
+var mockPermissionCodes = Permissions{"movies:read", "movies:write"}
+
+// NewMockModels returns a Models struct whose repositories keep their records in
+// memory. They follow the same rules as the PostgreSQL models (versions start at 1
+// and are bumped on every update, stale versions get ErrEditConflict, missing records
+// get ErrRecordNotFound, email addresses are unique regardless of case) so that the
+// handlers can be tested against them without a database.
+func NewMockModels() Models {
+	store := &mockStore{
+		movies:      make(map[int64]Movie),
+		users:       make(map[int64]User),
+		permissions: make(map[int64]Permissions),
+		apiKeys:     make(map[int64]APIKey),
+	}
+
+	return Models{
+		APIKeys:     mockAPIKeyModel{store},
+		Movies:      mockMovieModel{store},
+		Permissions: mockPermissionModel{store},
+		Tokens:      mockTokenModel{store},
+		Users:       mockUserModel{store},
+	}
+}
+
+type mockMovieModel struct {
+	store *mockStore
+}
+
+func (m mockMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastMovieID++
+	movie.ID = m.store.lastMovieID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	m.store.movies[movie.ID] = copyMovie(*movie)
+	return nil
+}
+
+func (m mockMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	movie = copyMovie(movie)
+	return &movie, nil
+}
+
+func (m mockMovieModel) Update(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.movies[movie.ID]
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	m.store.movies[movie.ID] = copyMovie(*movie)
+	return nil
+}
+
+func (m mockMovieModel) Delete(ctx context.Context, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.movies, id)
+	return nil
+}
+
+// GetAll filters, sorts and paginates the movies the same way as the SQL query in
+// MovieModel.GetAll(). The title filter matches when every word of the search appears
+// as a word in the title, ignoring case, which mirrors plainto_tsquery('simple', ...).
+func (m mockMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+	searchWords := words(title)
+
+	matches := []Movie{}
+	for _, movie := range m.store.movies {
+		titleWords := words(movie.Title)
+		if !containsAll(titleWords, searchWords) || !containsAll(movie.Genres, genres) {
+			continue
+		}
+		matches = append(matches, movie)
+	}
+
+	slices.SortFunc(matches, func(a, b Movie) int {
+		var c int
+		switch column {
+		case "title":
+			c = strings.Compare(a.Title, b.Title)
+		case "year":
+			c = cmp.Compare(a.Year, b.Year)
+		case "runtime":
+			c = cmp.Compare(a.Runtime, b.Runtime)
+		default:
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if direction == "DESC" {
+			c = -c
+		}
+		//Use the ID as a secondary sort, just like the SQL query
+		return cmp.Or(c, cmp.Compare(a.ID, b.ID))
+	})
+
+	movies := []*Movie{}
+	for i := filters.offset(); i < len(matches) && i < filters.offset()+filters.limit(); i++ {
+		movie := copyMovie(matches[i])
+		movies = append(movies, &movie)
+	}
+
+	//Like the count(*) OVER() window function, the total is only known when the
+	//requested page contains at least one record
+	totalRecords := 0
+	if len(movies) > 0 {
+		totalRecords = len(matches)
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+type mockUserModel struct {
+	store *mockStore
+}
+
+func (m mockUserModel) Insert(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.store.lastUserID++
+	user.ID = m.store.lastUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	m.store.users[user.ID] = *user
+	return nil
+}
+
+func (m mockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m mockUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (m mockUserModel) Update(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored, ok := m.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	m.store.users[user.ID] = *user
+	return nil
+}
+
+func (m mockUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	for _, token := range m.store.tokens {
+		if string(token.Hash) == string(hash[:]) && token.Scope == tokenScope && token.Expiry.After(time.Now()) {
+			user, ok := m.store.users[token.UserID]
+			if !ok {
+				break
+			}
+			return &user, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// emailTaken reports whether a user other than the one with the given ID already has
+// the email address. Like the citext column, the comparison ignores case.
+func (s *mockStore) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+type mockTokenModel struct {
+	store *mockStore
+}
+
+func (m mockTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+func (m mockTokenModel) Insert(ctx context.Context, token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.tokens = append(m.store.tokens, *token)
+	return nil
+}
+
+func (m mockTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return m.deleteWhere(func(t Token) bool {
+		return t.Scope == scope && t.UserID == userID
+	})
+}
+
+func (m mockTokenModel) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	return m.deleteWhere(func(t Token) bool {
+		return t.UserID == userID
+	})
+}
+
+func (m mockTokenModel) DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	return m.deleteWhere(func(t Token) bool {
+		return t.Scope == scope && string(t.Hash) == string(hash[:])
+	})
+}
+
+func (m mockTokenModel) deleteWhere(match func(Token) bool) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.tokens = slices.DeleteFunc(m.store.tokens, match)
+	return nil
+}
+
+type mockPermissionModel struct {
+	store *mockStore
+}
+
+func (m mockPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return slices.Clone(m.store.permissions[userID]), nil
+}
+
+func (m mockPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		if mockPermissionCodes.Include(code) && !m.store.permissions[userID].Include(code) {
+			m.store.permissions[userID] = append(m.store.permissions[userID], code)
+		}
+	}
+	return nil
+}
+
+type mockAPIKeyModel struct {
+	store *mockStore
+}
+
+func (m mockAPIKeyModel) New(ctx context.Context, userID int64, label string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := generateAPIKey(userID, label, permissions, expiry)
+
+	err := m.Insert(ctx, key)
+	return key, err
+}
+
+func (m mockAPIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastAPIKeyID++
+	key.ID = m.store.lastAPIKeyID
+	key.CreatedAt = time.Now().Truncate(time.Second)
+
+	//Only the hash is kept, just like the api_keys table
+	stored := *key
+	stored.Plaintext = ""
+	stored.Permissions = slices.Clone(key.Permissions)
+	m.store.apiKeys[key.ID] = stored
+
+	return nil
+}
+
+func (m mockAPIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	keys := []*APIKey{}
+	for _, key := range m.store.apiKeys {
+		if key.UserID == userID {
+			key.Permissions = slices.Clone(key.Permissions)
+			keys = append(keys, &key)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+
+	return keys, nil
+}
+
+func (m mockAPIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	key, ok := m.store.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.apiKeys, id)
+	return nil
+}
+
+func (m mockAPIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for id, key := range m.store.apiKeys {
+		if key.UserID == userID {
+			delete(m.store.apiKeys, id)
+		}
+	}
+	return nil
+}
+
+func (m mockAPIKeyModel) GetForKey(ctx context.Context, plaintext string) (*APIKey, *User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(plaintext))
+	now := time.Now()
+
+	for id, key := range m.store.apiKeys {
+		if string(key.Hash) != string(hash[:]) || (key.Expiry != nil && !key.Expiry.After(now)) {
+			continue
+		}
+
+		user, ok := m.store.users[key.UserID]
+		if !ok {
+			break
+		}
+
+		key.LastUsedAt = &now
+		m.store.apiKeys[id] = key
+
+		key.Permissions = slices.Clone(key.Permissions)
+		return &key, &user, nil
+	}
+
+	return nil, nil, ErrRecordNotFound
+}
+
+// copyMovie returns a copy of a movie which doesn't share its genres slice, so that
+// callers can't change a stored record by modifying a movie they were given.
+func copyMovie(movie Movie) Movie {
+	movie.Genres = slices.Clone(movie.Genres)
+	return movie
+}
+
+// words splits a string into lower-case words, the way the 'simple' text search
+// configuration does.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll reports whether every value in want is also in have.
+func containsAll(have, want []string) bool {
+	for _, value := range want {
+		if !slices.Contains(have, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMockModels(t *testing.T) {
+	testModels(t, func(t *testing.T) Models {
+		return NewMockModels()
+	})
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"The Breakfast Club", []string{"the", "breakfast", "club"}},
+		{"  Spider-Man: No Way Home ", []string{"spider", "man", "no", "way", "home"}},
+		{"WALL·E (2008)", []string{"wall", "e", "2008"}},
+		{"Amélie", []string{"amélie"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := words(tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("words(%q) = %q; want %q", tt.s, got, tt.want)
+		}
+	}
+}
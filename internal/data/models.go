@@ -1,24 +1,124 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
-//Define a custom ErrRecordNotFound error. will be returned from Get()
-//when looking up a movie that doesn't exist in the database
+// Define a custom ErrRecordNotFound error. will be returned from Get()
+// when looking up a movie that doesn't exist in the database.
+// ErrEditConflict is returned from Update() when the record's version has
+// changed since it was read, and ErrDuplicateEmail when a user's email address
+// is already taken.
+//
+// ErrQueryTimeout and ErrQueryCanceled are returned by any model method whose query
+// was cut short: ErrQueryTimeout when it ran for longer than the query timeout, and
+// ErrQueryCanceled when the caller's context was canceled (typically because the
+// client went away before the response was ready).
 var (
-	ErrRecordNotFound=errors.New("record not found")
+	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrQueryTimeout   = errors.New("query timed out")
+	ErrQueryCanceled  = errors.New("query canceled")
 )
 
-//Create a  models struct which wraps the MovieModel
-//we can now add other models to this later
-type Models struct{
-	Movies MovieModel
+// MovieRepository is implemented by anything that can store movies. MovieModel keeps
+// them in PostgreSQL; the in-memory implementation returned by NewMockModels() lets
+// the handlers be exercised without a database. Every implementation must return
+// ErrRecordNotFound and ErrEditConflict in the same situations.
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
 }
 
-//For ease of use, we also add a New() method which returns a models struct
-//containing the initialized MovieModel
-func NewModels(db *sql.DB)Models{
-	return  Models{Movies: MovieModel{DB: db}}
-}
\ No newline at end of file
+// UserRepository is implemented by anything that can store users.
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Get(ctx context.Context, id int64) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+}
+
+// TokenRepository is implemented by anything that can store tokens.
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteAllScopesForUser(ctx context.Context, userID int64) error
+	DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error
+}
+
+// PermissionRepository is implemented by anything that can store user permissions.
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// APIKeyRepository is implemented by anything that can store API keys.
+type APIKeyRepository interface {
+	New(ctx context.Context, userID int64, label string, permissions Permissions, expiry *time.Time) (*APIKey, error)
+	Insert(ctx context.Context, key *APIKey) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	Delete(ctx context.Context, id, userID int64) error
+	DeleteAllForUser(ctx context.Context, userID int64) error
+	GetForKey(ctx context.Context, plaintext string) (*APIKey, *User, error)
+}
+
+// Create a  models struct which wraps the repositories. The handlers only
+// depend on these interfaces, so the storage behind them can be swapped out
+type Models struct {
+	APIKeys     APIKeyRepository
+	Movies      MovieRepository
+	Permissions PermissionRepository
+	Tokens      TokenRepository
+	Users       UserRepository
+}
+
+// For ease of use, we also add a New() method which returns a models struct
+// containing the initialized MovieModel. Every query is given at most queryTimeout
+// to complete; a zero queryTimeout means queries are only bounded by the caller's
+// context.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		APIKeys:     APIKeyModel{DB: db, Timeout: queryTimeout},
+		Movies:      MovieModel{DB: db, Timeout: queryTimeout},
+		Permissions: PermissionModel{DB: db, Timeout: queryTimeout},
+		Tokens:      TokenModel{DB: db, Timeout: queryTimeout},
+		Users:       UserModel{DB: db, Timeout: queryTimeout},
+	}
+}
+
+// withTimeout returns a copy of ctx which is canceled after timeout, or ctx itself if
+// the timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError converts the error from a query whose context has ended into
+// ErrQueryTimeout or ErrQueryCanceled, so that callers can tell a slow database or a
+// departed client apart from other failures. We check the context rather than the
+// error itself, since the pq driver reports a canceled query as an ordinary
+// PostgreSQL error ("canceling statement due to user request").
+func queryError(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,568 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// migrate runs the migrations for the given dialect ("postgres" or "sqlite") in the
+// given direction ("up" or "down") against the database, in order for "up" and in
+// reverse order for "down".
+func migrate(t *testing.T, db *sql.DB, dialect, direction string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "migrations", dialect, "*."+direction+".sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no migrations found")
+	}
+
+	slices.Sort(files)
+	if direction == "down" {
+		slices.Reverse(files)
+	}
+
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(migration))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+}
+
+// testModels runs the repository tests against the models returned by newModels,
+// which is called once per test so that every test starts from empty tables. Each
+// implementation of the repositories is expected to pass the same tests.
+func testModels(t *testing.T, newModels func(t *testing.T) Models) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, m Models)
+	}{
+		{"movies", testMovies},
+		{"movies list", testMoviesGetAll},
+		{"users", testUsers},
+		{"tokens", testTokens},
+		{"permissions", testPermissions},
+		{"api keys", testAPIKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newModels(t))
+		})
+	}
+}
+
+// newUser inserts a user with the given email address. The password hash is set
+// directly, since the tests don't need a real bcrypt hash.
+func newUser(t *testing.T, m Models, email string) *User {
+	t.Helper()
+
+	user := &User{Name: "Test User", Email: email, Activated: true}
+	user.Password.hash = []byte("not a real hash")
+
+	err := m.Users.Insert(t.Context(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func testMovies(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	movie := &Movie{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}}
+	err := m.Movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.ID < 1 || movie.Version != 1 || movie.CreatedAt.IsZero() {
+		t.Fatalf("got ID %d, version %d and created at %v after Insert()", movie.ID, movie.Version, movie.CreatedAt)
+	}
+
+	got, err := m.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, movie) {
+		t.Errorf("got %+v; want %+v", got, movie)
+	}
+
+	//Changing a movie which has been read doesn't change the stored record
+	got.Genres[0] = "comedy"
+	again, err := m.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Genres[0] != "drama" {
+		t.Errorf("got genres %v; the stored movie was changed", again.Genres)
+	}
+
+	//Updates bump the version, and an update made with an old version conflicts
+	stale := *again
+	again.Runtime = 103
+	err = m.Movies.Update(ctx, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Version != 2 {
+		t.Errorf("got version %d after Update(); want 2", again.Version)
+	}
+
+	stale.Title = "Casablanca (1942)"
+	err = m.Movies.Update(ctx, &stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got %v from a stale Update(); want ErrEditConflict", err)
+	}
+
+	err = m.Movies.Update(ctx, &Movie{ID: 999, Title: "Missing", Version: 1})
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got %v updating a missing movie; want ErrEditConflict", err)
+	}
+
+	got, err = m.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Casablanca" || got.Runtime != 103 || got.Version != 2 {
+		t.Errorf("got %+v after the updates", got)
+	}
+
+	err = m.Movies.Delete(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Movies.Get(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v from Get() after Delete(); want ErrRecordNotFound", err)
+	}
+
+	err = m.Movies.Delete(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v deleting a missing movie; want ErrRecordNotFound", err)
+	}
+}
+
+func testMoviesGetAll(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	for _, movie := range []*Movie{
+		{Title: "Titanic", Year: 1997, Runtime: 194, Genres: []string{"drama", "romance"}},
+		{Title: "An American Tail", Year: 1986, Runtime: 80, Genres: []string{"animation", "adventure"}},
+		{Title: "The Breakfast Club", Year: 1985, Runtime: 97, Genres: []string{"comedy", "drama"}},
+		{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}},
+	} {
+		err := m.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	safelist := []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	tests := []struct {
+		name     string
+		title    string
+		genres   []string
+		page     int
+		pageSize int
+		sort     string
+		want     []string
+		metadata Metadata
+	}{
+		{
+			name: "everything",
+			want: []string{"Titanic", "An American Tail", "The Breakfast Club", "Black Panther"},
+		},
+		{
+			name:  "whole words only",
+			title: "an",
+			want:  []string{"An American Tail"},
+		},
+		{
+			name:  "every word, ignoring case",
+			title: "CLUB the",
+			want:  []string{"The Breakfast Club"},
+		},
+		{
+			name:  "no match",
+			title: "panther club",
+			want:  []string{},
+		},
+		{
+			name:   "genre",
+			genres: []string{"drama"},
+			want:   []string{"Titanic", "The Breakfast Club"},
+		},
+		{
+			name:   "every genre",
+			genres: []string{"romance", "drama"},
+			want:   []string{"Titanic"},
+		},
+		{
+			name:   "title and genre",
+			title:  "tail",
+			genres: []string{"adventure"},
+			want:   []string{"An American Tail"},
+		},
+		{
+			name: "sorted by year, descending",
+			sort: "-year",
+			want: []string{"Black Panther", "Titanic", "An American Tail", "The Breakfast Club"},
+		},
+		{
+			name: "sorted by title",
+			sort: "title",
+			want: []string{"An American Tail", "Black Panther", "The Breakfast Club", "Titanic"},
+		},
+		{
+			name:     "second page",
+			page:     2,
+			pageSize: 3,
+			sort:     "runtime",
+			want:     []string{"Titanic"},
+			metadata: Metadata{CurrentPage: 2, PageSize: 3, FirstPage: 1, LastPage: 2, TotalRecords: 4},
+		},
+		{
+			name:     "past the last page",
+			page:     3,
+			pageSize: 3,
+			want:     []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{
+				Page:         cmpOr(tt.page, 1),
+				PageSize:     cmpOr(tt.pageSize, 20),
+				Sort:         cmpOr(tt.sort, "id"),
+				SortSafelist: safelist,
+			}
+
+			movies, metadata, err := m.Movies.GetAll(ctx, tt.title, cmpOr(tt.genres, []string{}), filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			titles := []string{}
+			for _, movie := range movies {
+				titles = append(titles, movie.Title)
+			}
+			if !slices.Equal(titles, tt.want) {
+				t.Errorf("got %q; want %q", titles, tt.want)
+			}
+
+			if tt.metadata != (Metadata{}) && metadata != tt.metadata {
+				t.Errorf("got metadata %+v; want %+v", metadata, tt.metadata)
+			}
+			if len(tt.want) == 0 && metadata != (Metadata{}) {
+				t.Errorf("got metadata %+v for an empty page; want none", metadata)
+			}
+		})
+	}
+}
+
+// cmpOr returns value, or fallback if value is empty. It works like cmp.Or() but
+// for slices too.
+func cmpOr[T any](value, fallback T) T {
+	if reflect.ValueOf(&value).Elem().IsZero() {
+		return fallback
+	}
+	return value
+}
+
+func testUsers(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	alice := newUser(t, m, "alice@example.com")
+	if alice.ID < 1 || alice.Version != 1 {
+		t.Fatalf("got ID %d and version %d after Insert()", alice.ID, alice.Version)
+	}
+
+	//Email addresses are unique regardless of case
+	duplicate := &User{Name: "Alice", Email: "ALICE@example.com"}
+	duplicate.Password.hash = []byte("not a real hash")
+	err := m.Users.Insert(ctx, duplicate)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v inserting a duplicate email; want ErrDuplicateEmail", err)
+	}
+
+	got, err := m.Users.GetByEmail(ctx, "Alice@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != alice.ID || string(got.Password.hash) != "not a real hash" {
+		t.Errorf("got %+v from GetByEmail()", got)
+	}
+
+	_, err = m.Users.GetByEmail(ctx, "nobody@example.com")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for an unknown email; want ErrRecordNotFound", err)
+	}
+
+	_, err = m.Users.Get(ctx, 999)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for an unknown ID; want ErrRecordNotFound", err)
+	}
+
+	bob := newUser(t, m, "bob@example.com")
+
+	//Changing to an address another user has is rejected
+	bob.Email = "alice@EXAMPLE.com"
+	err = m.Users.Update(ctx, bob)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v updating to a duplicate email; want ErrDuplicateEmail", err)
+	}
+
+	bob.Email = "robert@example.com"
+	stale := *bob
+	err = m.Users.Update(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Version != 2 {
+		t.Errorf("got version %d after Update(); want 2", bob.Version)
+	}
+
+	err = m.Users.Update(ctx, &stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got %v from a stale Update(); want ErrEditConflict", err)
+	}
+
+	got, err = m.Users.Get(ctx, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "robert@example.com" || got.Version != 2 {
+		t.Errorf("got %+v after Update()", got)
+	}
+}
+
+func testTokens(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	alice := newUser(t, m, "alice@example.com")
+	bob := newUser(t, m, "bob@example.com")
+
+	auth, err := m.Tokens.New(ctx, alice.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation, err := m.Tokens.New(ctx, alice.ID, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := m.Tokens.New(ctx, alice.ID, -time.Minute, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := m.Tokens.New(ctx, bob.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(scope, plaintext string) int64 {
+		t.Helper()
+
+		user, err := m.Users.GetForToken(ctx, scope, plaintext)
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return 0
+		case err != nil:
+			t.Fatal(err)
+		}
+		return user.ID
+	}
+
+	tests := []struct {
+		name      string
+		scope     string
+		plaintext string
+		want      int64
+	}{
+		{"authentication token", ScopeAuthentication, auth.Plaintext, alice.ID},
+		{"activation token", ScopeActivation, activation.Plaintext, alice.ID},
+		{"wrong scope", ScopeActivation, auth.Plaintext, 0},
+		{"expired", ScopeAuthentication, expired.Plaintext, 0},
+		{"unknown", ScopeAuthentication, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", 0},
+		{"another user's token", ScopeAuthentication, bobs.Plaintext, bob.ID},
+	}
+
+	for _, tt := range tests {
+		if got := lookup(tt.scope, tt.plaintext); got != tt.want {
+			t.Errorf("%s: got user %d; want %d", tt.name, got, tt.want)
+		}
+	}
+
+	err = m.Tokens.DeleteAllForUser(ctx, ScopeActivation, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup(ScopeActivation, activation.Plaintext) != 0 || lookup(ScopeAuthentication, auth.Plaintext) != alice.ID {
+		t.Error("DeleteAllForUser() didn't delete only the activation tokens")
+	}
+
+	err = m.Tokens.DeleteForToken(ctx, ScopeAuthentication, auth.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup(ScopeAuthentication, auth.Plaintext) != 0 {
+		t.Error("DeleteForToken() didn't delete the token")
+	}
+
+	_, err = m.Tokens.New(ctx, alice.ID, time.Hour, ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := m.Tokens.New(ctx, alice.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Tokens.DeleteAllScopesForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup(ScopeAuthentication, again.Plaintext) != 0 || lookup(ScopeAuthentication, bobs.Plaintext) != bob.ID {
+		t.Error("DeleteAllScopesForUser() didn't delete only the user's tokens")
+	}
+}
+
+func testPermissions(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	alice := newUser(t, m, "alice@example.com")
+
+	got, err := m.Permissions.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got permissions %v for a new user; want none", got)
+	}
+
+	//Adding a permission twice, or one which doesn't exist, isn't an error
+	err = m.Permissions.AddForUser(ctx, alice.ID, "movies:read", "movies:read", "movies:delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Permissions.AddForUser(ctx, alice.ID, "movies:write", "movies:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = m.Permissions.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if want := (Permissions{"movies:read", "movies:write"}); !slices.Equal(got, want) {
+		t.Errorf("got permissions %v; want %v", got, want)
+	}
+}
+
+func testAPIKeys(t *testing.T, m Models) {
+	ctx := t.Context()
+
+	alice := newUser(t, m, "alice@example.com")
+	bob := newUser(t, m, "bob@example.com")
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	past := time.Now().Add(-time.Hour)
+
+	key, err := m.APIKeys.New(ctx, alice.ID, "ci", Permissions{"movies:read"}, &expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID < 1 || len(key.Plaintext) == 0 || key.Prefix == "" {
+		t.Fatalf("got %+v from New()", key)
+	}
+
+	expired, err := m.APIKeys.New(ctx, alice.ID, "old", Permissions{"movies:read"}, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := m.APIKeys.New(ctx, bob.ID, "bob", Permissions{"movies:read", "movies:write"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, user, err := m.APIKeys.GetForKey(ctx, key.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || user.ID != alice.ID || !slices.Equal(got.Permissions, key.Permissions) {
+		t.Errorf("got key %+v for user %d from GetForKey()", got, user.ID)
+	}
+	if got.LastUsedAt == nil {
+		t.Error("GetForKey() didn't record when the key was used")
+	}
+	if got.Expiry == nil || !got.Expiry.Equal(expiry) {
+		t.Errorf("got expiry %v; want %v", got.Expiry, expiry)
+	}
+
+	for name, plaintext := range map[string]string{"expired": expired.Plaintext, "unknown": APIKeyPrefix + "ABCDEFGHIJKLMNOPQRSTUVWXYZ"} {
+		_, _, err = m.APIKeys.GetForKey(ctx, plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got %v for an %s key; want ErrRecordNotFound", err, name)
+		}
+	}
+
+	//The listing is newest first and never includes the plaintext
+	keys, err := m.APIKeys.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != expired.ID || keys[1].ID != key.ID {
+		t.Fatalf("got %d keys from GetAllForUser(); want keys %d and %d", len(keys), expired.ID, key.ID)
+	}
+	for _, k := range keys {
+		if k.Plaintext != "" {
+			t.Errorf("got the plaintext of key %d from GetAllForUser()", k.ID)
+		}
+	}
+
+	//A user can't delete somebody else's key
+	err = m.APIKeys.Delete(ctx, bobs.ID, alice.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v deleting another user's key; want ErrRecordNotFound", err)
+	}
+
+	err = m.APIKeys.Delete(ctx, expired.ID, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.APIKeys.DeleteAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err = m.APIKeys.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("got %d keys after DeleteAllForUser(); want none", len(keys))
+	}
+
+	_, user, err = m.APIKeys.GetForKey(ctx, bobs.Plaintext)
+	if err != nil || user.ID != bob.ID {
+		t.Errorf("got user %v and error %v for another user's key after DeleteAllForUser()", user, err)
+	}
+}
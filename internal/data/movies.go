@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/greenlight-api/validator"
@@ -11,56 +14,64 @@ import (
 
 //To control the visibility of individual struct fields in the JSON
 //use omitzero and - struct tag directives.
+//The validate struct tags hold the rules checked by ValidateMovie()
 
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitzero"`
-	Runtime   int32     `json:"runtime,omitzero"`
-	Genres    []string  `json:"genres,omitzero"`
+	Title     string    `json:"title" validate:"required,max=500"`
+	Year      int32     `json:"year,omitzero" validate:"required,min=1888,not_future"`
+	Runtime   int32     `json:"runtime,omitzero" validate:"required,positive"`
+	Genres    []string  `json:"genres,omitzero" validate:"required,min=1,max=5,unique,dive,required"`
 	Version   int32     `json:"version"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.Struct(movie)
+}
+
+// The not_future rule checks that a year isn't later than the current year.
+func init() {
+	validator.RegisterRule("not_future", validator.Rule{
+		Check: func(field reflect.Value, _ string) bool {
+			return field.Int() <= int64(time.Now().Year())
+		},
+		Message: func(reflect.Value, string) string {
+			return "must not be in the future"
+		},
+	})
 }
 
 // MovieModel struct type that wraps a sql.DB connection pool
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
 // Add a placeholder method for inserting a new record in the movies table
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
 	//SQL querry for insrting a new record in the movies table and returning
-	//system-generated data
+	//system-generated data. The version is left to the column default, so every
+	//movie starts at version 1
 	query := `
-	INSERT INTO movies (title,year,runtime,genres,version)
-	VALUES ($1,$2,$3,$4,$5)
+	INSERT INTO movies (title,year,runtime,genres)
+	VALUES ($1,$2,$3,$4)
 	RETURNING id, created_at,version
 	`
 
 	//Args slice containing the values for the placeholder parameters from
 	//the movie struct.Declaring it immediately next to the sql query
 	//makes it clear what values are used in the query
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Version}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
 	//use the QueryRow() method to execute the SQL query on the connection pool,
 	//passing in the args slice as a variadic parameter and scanning the system-generated
 	//id , created_at and version values into the movie struct
-	return m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return queryError(ctx, err)
 
 	//Because the Insert() method takes a *Movie pointer as the parameter, when we call Scan() to read in the
 	//system-generated data we're updating the values at the location the parameter points to.
@@ -69,7 +80,10 @@ func (m MovieModel) Insert(movie *Movie) error {
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
 	//The PostgreSQL bigserial type we use for the  movie ID starts auto-incrementing
 	//at 1 by default, so no movie will have ID values less than that.
 	//To avoid making unnecessary database call, we take a shortcut and return an
@@ -89,7 +103,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	//Execute the query using the QueryRow() method, passing in the provided id value
 	//as a placeholder parameter, and scan the response data into the fields of the movie struct
 	//we convert the scan target for the genres column using the pq.Array() adapter function again.
-	err := m.DB.QueryRow(Query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, Query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -108,7 +122,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
@@ -116,15 +130,22 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Add a placeholder method for updating a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+// Update writes the changes to a specific record in the movies table. The update
+// only succeeds if the version number in the database still matches the version
+// in the movie struct, so if two clients fetch and edit the same movie at the same
+// time, the second one to write gets an ErrEditConflict instead of silently
+// overwriting the first one's changes.
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
 	//Declare the SQL query for updating the record and returning the new version
 	//number
 
 	query := `
 	UPDATE movies
 	SET title=$1, year=$2, runtime=$3, genres=$4, version=version+1
-	WHERE id = $5
+	WHERE id = $5 AND version = $6
 	RETURNING version
 	`
 	// Create an args slice containing the values for the placeholder parameters.
@@ -134,14 +155,126 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Runtime,
 		pq.Array(movie.Genres),
 		movie.ID,
+		movie.Version,
+	}
+
+	//Execute the SQL query. If no matching row could be found, we know the movie
+	//version has changed (or the record has been deleted) and we return our
+	//custom ErrEditConflict error.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
 	}
 
-	//Use the QueryRow() method to execute the query, passing in the args slice as 
-	//variadic parameter and scanning the new version value into the movie struct
-	return  m.DB.QueryRow(query, args...).Scan(&movie.Version)
+	return nil
 }
 
-// Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+// Delete removes a specific record from the movies table.
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	//Return an ErrRecordNotFound error if the movie ID is less than 1
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM movies
+	WHERE id = $1
+	`
+
+	//Execute the SQL query using the Exec() method, passing in the id variable as
+	//the value for the placeholder parameter. The Exec() method returns a sql.Result
+	//object.
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	//Call the RowsAffected() method on the sql.Result object to get the number of rows
+	//affected by the query
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	//If no rows were affected, we know that the movies table didn't contain a record
+	//with the provided ID at the moment we tried to delete it. In that case we
+	//return an ErrRecordNotFound error.
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
+
+// GetAll returns a slice of movies matching the title and genres filters, along
+// with the pagination metadata for the result set.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	//The title filter uses PostgreSQL full-text search, so searching for "the club"
+	//will match "The Breakfast Club". The genres filter uses the @> contains operator.
+	//Either filter is skipped when its placeholder is empty. The count(*) OVER()
+	//window function gives us the total number of filtered records alongside each
+	//row. The sort column and direction come from the safelist, so it is safe to
+	//interpolate them, and we add id as a secondary sort to keep the order stable.
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (genres @> $2 OR $2 = '{}')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	//Defer a call to rows.Close() to ensure that the resultset is closed
+	//before GetAll() returns
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	//When the rows.Next() loop has finished, call rows.Err() to retrieve any error
+	//that was encountered during the iteration
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
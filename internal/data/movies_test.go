@@ -0,0 +1,87 @@
+package data
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/greenlight-api/validator"
+)
+
+func TestValidateMovie(t *testing.T) {
+	nextYear := int32(time.Now().Year() + 1)
+
+	tests := []struct {
+		name  string
+		movie Movie
+		want  map[string][]string
+	}{
+		{
+			name:  "valid",
+			movie: Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "missing fields",
+			movie: Movie{},
+			want: map[string][]string{
+				"title":   {"required"},
+				"year":    {"required", "min"},
+				"runtime": {"required", "positive"},
+				"genres":  {"required", "min"},
+			},
+		},
+		{
+			name: "out of range",
+			movie: Movie{
+				Title:   string(make([]byte, 501)),
+				Year:    nextYear,
+				Runtime: -5,
+				Genres:  []string{"a", "b", "c", "d", "e", "a"},
+			},
+			want: map[string][]string{
+				"title":   {"max"},
+				"year":    {"not_future"},
+				"runtime": {"positive"},
+				"genres":  {"max", "unique"},
+			},
+		},
+		{
+			name:  "empty genre",
+			movie: Movie{Title: "Moana", Year: 1887, Runtime: 107, Genres: []string{"animation", ""}},
+			want: map[string][]string{
+				"year":     {"min"},
+				"genres/1": {"required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateMovie(v, &tt.movie)
+
+			got := make(map[string][]string)
+			for key, fieldErrors := range v.Errors {
+				for _, fe := range fieldErrors {
+					got[key] = append(got[key], fe.Code)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMovieParams(t *testing.T) {
+	v := validator.New()
+	ValidateMovie(v, &Movie{Title: "Moana", Year: 1800, Runtime: 107, Genres: []string{"animation"}})
+
+	want := []validator.FieldError{{Code: "min", Message: "must be at least 1888", Params: map[string]string{"min": strconv.Itoa(1888)}}}
+	if !reflect.DeepEqual(v.Errors["year"], want) {
+		t.Errorf("got %v; want %v", v.Errors["year"], want)
+	}
+}
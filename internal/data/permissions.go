@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a Permissions slice, which we will use to hold the permission codes (like
+// "movies:read" and "movies:write") for a single user.
+type Permissions []string
+
+// Include checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// PermissionModel struct type that wraps a sql.DB connection pool
+type PermissionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// The GetAllForUser() method returns all permission codes for a specific user in a
+// Permissions slice.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	INNER JOIN users ON users_permissions.user_id = users.id
+	WHERE users.id = $1
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return permissions, nil
+}
+
+// Add the provided permission codes for a specific user. Notice that we're using a
+// variadic parameter for the codes so that we can assign multiple permissions in a
+// single call.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO users_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return queryError(ctx, err)
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPostgresDB creates a new schema in the database given by the
+// GREENLIGHT_TEST_DB_DSN environment variable, and returns a connection pool which
+// uses it. The schema is dropped when the test finishes. Tests which need PostgreSQL
+// are skipped if the variable isn't set.
+func newTestPostgresDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "greenlight_test_" + strings.ToLower(rand.Text())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	//Every connection in the pool needs the schema on its search path, so set it in
+	//the DSN rather than with a SET statement. public stays on the path for the
+	//citext extension
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "&"
+		if !strings.Contains(dsn, "?") {
+			sep = "?"
+		}
+	}
+
+	db, err := sql.Open("postgres", dsn+sep+"search_path="+schema+",public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestPostgresModels(t *testing.T) {
+	testModels(t, func(t *testing.T) Models {
+		db := newTestPostgresDB(t)
+		migrate(t, db, "postgres", "up")
+		return NewModels(db, 5*time.Second)
+	})
+}
@@ -0,0 +1,722 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The SQLite models implement the same repositories as the PostgreSQL models against
+// the schema in migrations/sqlite, so that the API can run without a PostgreSQL server
+// for local development and demos. The differences from PostgreSQL are:
+//
+//   - Arrays (movie genres and API key permissions) are stored as JSON text and
+//     queried with the json_each() table-valued function.
+//   - Instead of relying on RETURNING, generated values are worked out in Go: the
+//     creation time is set before the insert, the ID is read with LastInsertId(),
+//     and version numbers are bumped in the struct once the UPDATE has matched a row.
+//   - Times are stored in UTC, so that they compare correctly as text.
+//   - The title filter in GetAll() splits the title into words with the title_words()
+//     SQL function, which is registered by the SQLiteDriver and uses the same rules as
+//     the 'simple' text search configuration, so "an" matches "An American Tail" but
+//     not "Titanic", just like in PostgreSQL.
+
+// SQLiteDriver is the name of the database/sql driver which the SQLite models need:
+// the go-sqlite3 driver with the title_words() function registered on every
+// connection. go-sqlite3 needs cgo, so in builds without cgo the driver is a stub
+// which refuses to open any database (see sqlite_nocgo.go), and only PostgreSQL can
+// be used.
+const SQLiteDriver = "sqlite3_greenlight"
+
+// NewSQLiteModels returns a Models struct backed by a SQLite database, which must
+// have been opened with the SQLiteDriver.
+func NewSQLiteModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		APIKeys:     SQLiteAPIKeyModel{DB: db, Timeout: queryTimeout},
+		Movies:      SQLiteMovieModel{DB: db, Timeout: queryTimeout},
+		Permissions: SQLitePermissionModel{DB: db, Timeout: queryTimeout},
+		Tokens:      SQLiteTokenModel{DB: db, Timeout: queryTimeout},
+		Users:       SQLiteUserModel{DB: db, Timeout: queryTimeout},
+	}
+}
+
+// sqliteNow returns the current time in UTC, rounded down to the second like the
+// timestamp(0) columns in PostgreSQL.
+func sqliteNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// jsonList encodes a slice of strings as a JSON array. A nil slice is encoded as []
+// rather than null.
+func jsonList(values []string) (string, error) {
+	if values == nil {
+		values = []string{}
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+// SQLiteMovieModel stores movies in SQLite.
+type SQLiteMovieModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m SQLiteMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	genres, err := jsonList(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO movies (created_at, title, year, runtime, genres, version)
+	VALUES (?, ?, ?, ?, ?, 1)
+	`
+
+	createdAt := sqliteNow()
+	args := []any{createdAt, movie.Title, movie.Year, movie.Runtime, genres}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	movie.ID = id
+	movie.CreatedAt = createdAt
+	movie.Version = 1
+
+	return nil
+}
+
+func (m SQLiteMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE id = ?
+	`
+
+	var movie Movie
+	var genres string
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		&genres,
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	err = json.Unmarshal([]byte(genres), &movie.Genres)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+func (m SQLiteMovieModel) Update(ctx context.Context, movie *Movie) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	genres, err := jsonList(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE movies
+	SET title = ?, year = ?, runtime = ?, genres = ?, version = version + 1
+	WHERE id = ? AND version = ?
+	`
+
+	args := []any{movie.Title, movie.Year, movie.Runtime, genres, movie.ID, movie.Version}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	//If no row matched, the version has changed (or the movie has been deleted) since
+	//it was read
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+
+	return nil
+}
+
+func (m SQLiteMovieModel) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movies WHERE id = ?`, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m SQLiteMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	wantedWords, err := jsonList(words(title))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	wantedGenres, err := jsonList(genres)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	//The filters keep movies for which none of the wanted title words is missing from
+	//the words of the movie's title, and none of the wanted genres is missing from
+	//the movie's genres
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE NOT EXISTS (
+		SELECT 1 FROM json_each(?) AS wanted
+		WHERE wanted.value NOT IN (SELECT value FROM json_each(title_words(movies.title)))
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM json_each(?) AS wanted
+		WHERE wanted.value NOT IN (SELECT value FROM json_each(movies.genres))
+	)
+	ORDER BY %s %s, id ASC
+	LIMIT ? OFFSET ?`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{wantedWords, wantedGenres, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		var genres string
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&genres,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		err = json.Unmarshal([]byte(genres), &movie.Genres)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// SQLiteUserModel stores users in SQLite.
+type SQLiteUserModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m SQLiteUserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO users (created_at, name, email, password_hash, activated, version)
+	VALUES (?, ?, ?, ?, ?, 1)
+	`
+
+	createdAt := sqliteNow()
+	args := []any{createdAt, user.Name, user.Email, user.Password.hash, user.Activated}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users.email"):
+			return ErrDuplicateEmail
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	user.ID = id
+	user.CreatedAt = createdAt
+	user.Version = 1
+
+	return nil
+}
+
+// getWhere returns the first user matching the condition, which is added to a query
+// selecting every user column.
+func (m SQLiteUserModel) getWhere(ctx context.Context, condition string, args ...any) (*User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	` + condition
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+func (m SQLiteUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	//The email column uses the NOCASE collation, so this comparison ignores case
+	//just like the citext column in PostgreSQL
+	return m.getWhere(ctx, `WHERE email = ?`, email)
+}
+
+func (m SQLiteUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	return m.getWhere(ctx, `WHERE id = ?`, id)
+}
+
+func (m SQLiteUserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	UPDATE users
+	SET name = ?, email = ?, password_hash = ?, activated = ?, version = version + 1
+	WHERE id = ? AND version = ?
+	`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users.email"):
+			return ErrDuplicateEmail
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	user.Version++
+
+	return nil
+}
+
+func (m SQLiteUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	return m.getWhere(ctx, `
+	INNER JOIN tokens ON users.id = tokens.user_id
+	WHERE tokens.hash = ? AND tokens.scope = ? AND tokens.expiry > ?
+	`, tokenHash[:], tokenScope, time.Now().UTC())
+}
+
+// SQLiteTokenModel stores tokens in SQLite.
+type SQLiteTokenModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m SQLiteTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+func (m SQLiteTokenModel) Insert(ctx context.Context, token *Token) error {
+	return m.exec(ctx, `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES (?, ?, ?, ?)
+	`, token.Hash, token.UserID, token.Expiry.UTC(), token.Scope)
+}
+
+func (m SQLiteTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return m.exec(ctx, `DELETE FROM tokens WHERE scope = ? AND user_id = ?`, scope, userID)
+}
+
+func (m SQLiteTokenModel) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	return m.exec(ctx, `DELETE FROM tokens WHERE user_id = ?`, userID)
+}
+
+func (m SQLiteTokenModel) DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	return m.exec(ctx, `DELETE FROM tokens WHERE scope = ? AND hash = ?`, scope, tokenHash[:])
+}
+
+func (m SQLiteTokenModel) exec(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+// SQLitePermissionModel stores user permissions in SQLite.
+type SQLitePermissionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m SQLitePermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	WHERE users_permissions.user_id = ?
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return permissions, nil
+}
+
+func (m SQLitePermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	list, err := jsonList(codes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT OR IGNORE INTO users_permissions (user_id, permission_id)
+	SELECT ?, permissions.id FROM permissions
+	WHERE permissions.code IN (SELECT value FROM json_each(?))
+	`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, list)
+	return queryError(ctx, err)
+}
+
+// SQLiteAPIKeyModel stores API keys in SQLite.
+type SQLiteAPIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m SQLiteAPIKeyModel) New(ctx context.Context, userID int64, label string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := generateAPIKey(userID, label, permissions, expiry)
+
+	err := m.Insert(ctx, key)
+	return key, err
+}
+
+func (m SQLiteAPIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	permissions, err := jsonList(key.Permissions)
+	if err != nil {
+		return err
+	}
+
+	var expiry *time.Time
+	if key.Expiry != nil {
+		utc := key.Expiry.UTC()
+		expiry = &utc
+	}
+
+	query := `
+	INSERT INTO api_keys (user_id, label, prefix, hash, permissions, created_at, expiry)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	createdAt := sqliteNow()
+	args := []any{key.UserID, key.Label, key.Prefix, key.Hash, permissions, createdAt, expiry}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	key.ID = id
+	key.CreatedAt = createdAt
+
+	return nil
+}
+
+func (m SQLiteAPIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, label, prefix, permissions, created_at, last_used_at, expiry
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+		var permissions string
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Label,
+			&key.Prefix,
+			&permissions,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		err = json.Unmarshal([]byte(permissions), &key.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+func (m SQLiteAPIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m SQLiteAPIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM api_keys WHERE user_id = ?`, userID)
+	return queryError(ctx, err)
+}
+
+// GetForKey stamps last_used_at on an unexpired key and then reads the key and its
+// owner back. The PostgreSQL model does both in one statement with UPDATE ...
+// RETURNING; here an UPDATE which matches no rows means there is no such key.
+func (m SQLiteAPIKeyModel) GetForKey(ctx context.Context, plaintext string) (*APIKey, *User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plaintext))
+	now := sqliteNow()
+
+	result, err := m.DB.ExecContext(ctx, `
+	UPDATE api_keys
+	SET last_used_at = ?
+	WHERE hash = ? AND (expiry IS NULL OR expiry > ?)
+	`, now, hash[:], now)
+	if err != nil {
+		return nil, nil, queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT api_keys.id, api_keys.label, api_keys.prefix, api_keys.permissions, api_keys.created_at,
+		api_keys.last_used_at, api_keys.expiry,
+		users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM api_keys
+	INNER JOIN users ON users.id = api_keys.user_id
+	WHERE api_keys.hash = ?
+	`
+
+	var key APIKey
+	var user User
+	var permissions string
+
+	err = m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID,
+		&key.Label,
+		&key.Prefix,
+		&permissions,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, queryError(ctx, err)
+		}
+	}
+
+	err = json.Unmarshal([]byte(permissions), &key.Permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key.UserID = user.ID
+
+	return &key, &user, nil
+}
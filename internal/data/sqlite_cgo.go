@@ -0,0 +1,33 @@
+//go:build cgo
+
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sql.Register(SQLiteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			//title_words('Spider-Man: No Way Home') returns the JSON array
+			//'["spider","man","no","way","home"]'. It is deterministic, so SQLite
+			//can skip calling it again for the same title
+			return conn.RegisterFunc("title_words", func(title string) (string, error) {
+				return jsonList(words(title))
+			}, true)
+		},
+	})
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure on the
+// given column, such as "users.email".
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteErr.Error(), column)
+}
@@ -0,0 +1,31 @@
+//go:build !cgo
+
+package data
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// errSQLiteUnavailable is returned when opening a SQLite database in a build without
+// cgo, which the go-sqlite3 driver needs.
+var errSQLiteUnavailable = errors.New("SQLite support needs a build with cgo enabled")
+
+// sqliteStubDriver takes the place of the SQLiteDriver in builds without cgo, so that
+// a SQLite DSN gets a clear error at startup rather than "unknown driver".
+type sqliteStubDriver struct{}
+
+func (sqliteStubDriver) Open(string) (driver.Conn, error) {
+	return nil, errSQLiteUnavailable
+}
+
+func init() {
+	sql.Register(SQLiteDriver, sqliteStubDriver{})
+}
+
+// isUniqueViolation always reports false, since no SQLite database can be opened
+// without cgo.
+func isUniqueViolation(err error, column string) bool {
+	return false
+}
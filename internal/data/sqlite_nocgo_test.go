@@ -0,0 +1,21 @@
+//go:build !cgo
+
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestSQLiteUnavailable(t *testing.T) {
+	db, err := sql.Open(SQLiteDriver, "greenlight.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); !errors.Is(err, errSQLiteUnavailable) {
+		t.Errorf("got error %v; want errSQLiteUnavailable", err)
+	}
+}
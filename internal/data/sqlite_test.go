@@ -0,0 +1,101 @@
+//go:build cgo
+
+package data
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteDB opens a new SQLite database in a temporary directory, the same way
+// the API does, and closes it when the test finishes.
+func newTestSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(SQLiteDriver, filepath.Join(t.TempDir(), "greenlight.db")+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestSQLiteModels(t *testing.T) {
+	testModels(t, func(t *testing.T) Models {
+		db := newTestSQLiteDB(t)
+		migrate(t, db, "sqlite", "up")
+		return NewSQLiteModels(db, 5*time.Second)
+	})
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	db := newTestSQLiteDB(t)
+
+	tables := func() []string {
+		t.Helper()
+
+		rows, err := db.Query(`
+		SELECT name FROM sqlite_master
+		WHERE type IN ('table', 'index', 'trigger', 'view') AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		names := []string{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	migrate(t, db, "sqlite", "up")
+	for _, table := range []string{"api_keys", "movies", "permissions", "tokens", "users", "users_permissions"} {
+		if !slices.Contains(tables(), table) {
+			t.Errorf("got %q after migrating up; want a %s table", tables(), table)
+		}
+	}
+
+	//Permission codes are unique, so the permissions granted to a user can't be
+	//listed twice
+	_, err := db.Exec("INSERT INTO permissions (code) VALUES ('movies:read')")
+	if !isUniqueViolation(err, "permissions.code") {
+		t.Errorf("got error %v for a duplicate permission code; want a UNIQUE constraint failure", err)
+	}
+
+	//The down migrations undo everything, and the up migrations can be run again
+	//afterwards
+	migrate(t, db, "sqlite", "down")
+	if got := tables(); len(got) != 0 {
+		t.Errorf("got %q after migrating down; want nothing", got)
+	}
+
+	migrate(t, db, "sqlite", "up")
+}
+
+func TestSQLiteTitleWords(t *testing.T) {
+	db := newTestSQLiteDB(t)
+
+	var got string
+	err := db.QueryRow("SELECT title_words(?)", "Spider-Man: No Way Home").Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `["spider","man","no","way","home"]`; got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
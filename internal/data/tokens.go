@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/greenlight-api/validator"
+)
+
+// Define constants for the token scope. The scope is stored alongside each token so
+// that a token issued for one purpose can't be used for another.
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+)
+
+// Define a Token struct to hold the data for an individual token. This includes the
+// plaintext and hashed versions of the token, associated user ID, expiry time and
+// scope. Only the plaintext is ever sent to the client; the database only stores the
+// SHA-256 hash, so a leaked tokens table can't be used to act as a user.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// generateToken creates a new token for a user with the given time-to-live and scope.
+func generateToken(userID int64, ttl time.Duration, scope string) *Token {
+	//Create a Token instance. We add the provided ttl (time-to-live) duration
+	//parameter to the current time to get the expiry time
+	token := &Token{
+		//rand.Text() returns 26 characters of base32 text generated from 128 bits
+		//of cryptographically secure randomness
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+
+	//Generate a SHA-256 hash of the plaintext token string. This will be the value
+	//that we store in the `hash` field of our database table. Note that the
+	//sha256.Sum256() function returns an *array* of length 32, so to make it easier
+	//to work with we convert it to a slice using the [:] operator before storing it
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token
+}
+
+// ValidateTokenPlaintext checks that the plaintext token has been provided and is
+// exactly 26 bytes long.
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Var(tokenPlaintext, "token", "required,len=26")
+}
+
+// TokenModel struct type that wraps a sql.DB connection pool
+type TokenModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// The New() method is a shortcut which creates a new Token struct and then inserts the
+// data in the tokens table.
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+// Insert() adds the data for a specific token to the tokens table.
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES ($1, $2, $3, $4)
+	`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+// DeleteAllForUser() deletes all tokens for a specific user and scope.
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return queryError(ctx, err)
+}
+
+// DeleteAllScopesForUser() deletes every token belonging to a specific user, whatever
+// its scope. It is used after a password reset so that anyone holding one of the
+// user's old tokens is logged out.
+func (m TokenModel) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return queryError(ctx, err)
+}
+
+// DeleteForToken() deletes a single token, given its scope and plaintext value. It is
+// used to log out, revoking just the token the request was made with.
+func (m TokenModel) DeleteForToken(ctx context.Context, scope, tokenPlaintext string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND hash = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return queryError(ctx, err)
+}
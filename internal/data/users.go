@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/greenlight-api/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AnonymousUser represents a request which didn't include any authentication
+// credentials. The authenticate middleware stores it in the request context so that
+// handlers always have a user to look at.
+var AnonymousUser = &User{}
+
+// Define a User struct to represent an individual user. The json:"-" struct tag
+// keeps the Password field out of any JSON we send to the client.
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name" validate:"required,max=500"`
+	Email     string    `json:"email" validate:"required,email"`
+	Password  password  `json:"-" validate:"-"`
+	Activated bool      `json:"activated"`
+	Version   int       `json:"-"`
+}
+
+// IsAnonymous checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// The password type holds the plaintext and hashed versions of a user's password.
+// The plaintext field is a pointer so that we can tell a password which was never
+// set (nil) apart from an empty string.
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+// Set calculates the bcrypt hash of a plaintext password, and stores both the hash
+// and the plaintext versions in the struct.
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+
+	return nil
+}
+
+// Matches checks whether the provided plaintext password matches the hashed
+// password stored in the struct, returning true if it matches and false otherwise.
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Var(email, "email", "required,email")
+}
+
+// ValidatePasswordPlaintext checks that the password is between 8 and 72 bytes long,
+// since bcrypt only uses the first 72 bytes.
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Var(password, "password", "required,min=8,max=72")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	//Check the name and email against the rules in the User struct tags
+	v.Struct(user)
+
+	//If the plaintext password is not nil, call the standalone
+	//ValidatePasswordPlaintext() helper
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	//If the password hash is ever nil, this will be due to a logic error in our
+	//codebase (probably because we forgot to set a password for the user). It's a
+	//useful sanity check to include here, but it's not a problem with the data
+	//provided by the client. So rather than adding an error to the validation map we
+	//raise a panic instead.
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+// UserModel struct type that wraps a sql.DB connection pool
+type UserModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert a new record in the database for the user. The id, created_at and version
+// fields are all automatically generated by our database, so we use the RETURNING
+// clause to read them into the User struct after the insert.
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO users (name, email, password_hash, activated)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version
+	`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	//If the table already contains a record with this email address, then when we
+	//try to perform the insert there will be a violation of the UNIQUE
+	//"users_email_key" constraint that we set up in the migration. We check for
+	//this error specifically, and return a custom ErrDuplicateEmail error instead.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Retrieve the User details from the database based on the user's email address.
+// Because we have a UNIQUE constraint on the email column, this SQL query will only
+// return one record (or none at all, in which case we return a ErrRecordNotFound error).
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE email = $1
+	`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+// Get retrieves a user by ID. It is used by the JWT authentication mode, where the
+// token only carries the user's ID rather than being looked up in the tokens table.
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1
+	`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+// Update the details for a specific user. Like MovieModel.Update() we check the
+// version field to prevent race conditions during the request cycle, and we also
+// check for a violation of the "users_email_key" constraint.
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	UPDATE users
+	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version
+	`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// GetForToken retrieves the user associated with a token, as long as the token has the
+// given scope and hasn't expired yet.
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	//Calculate the SHA-256 hash of the plaintext token provided by the client.
+	//Remember that this returns a byte *array* with length 32, not a slice.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = $2
+	AND tokens.expiry > $3
+	`
+
+	//Create a slice containing the query arguments. Notice how we use the [:] operator
+	//to get a slice containing the token hash, rather than passing in the array (which
+	//is not supported by the pq driver), and that we pass the current time as the
+	//value to check against the token expiry.
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
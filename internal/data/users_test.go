@@ -0,0 +1,93 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/greenlight-api/validator"
+)
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     User
+		password string
+		want     map[string][]string
+	}{
+		{
+			name:     "valid",
+			user:     User{Name: "Alice", Email: "alice@example.com"},
+			password: "pa55word",
+			want:     map[string][]string{},
+		},
+		{
+			name:     "missing fields",
+			user:     User{},
+			password: "",
+			want: map[string][]string{
+				"name":     {"required"},
+				"email":    {"required", "email"},
+				"password": {"required", "min"},
+			},
+		},
+		{
+			name:     "invalid fields",
+			user:     User{Name: string(make([]byte, 501)), Email: "alice"},
+			password: "short",
+			want: map[string][]string{
+				"name":     {"max"},
+				"email":    {"email"},
+				"password": {"min"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//Set the fields directly rather than calling Set(), to keep bcrypt out
+			//of the test
+			tt.user.Password.plaintext = &tt.password
+			tt.user.Password.hash = []byte("hash")
+
+			v := validator.New()
+			ValidateUser(v, &tt.user)
+
+			got := make(map[string][]string)
+			for key, fieldErrors := range v.Errors {
+				for _, fe := range fieldErrors {
+					got[key] = append(got[key], fe.Code)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUserPanicsWithoutHash(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a user without a password hash")
+		}
+	}()
+
+	ValidateUser(validator.New(), &User{Name: "Alice", Email: "alice@example.com"})
+}
+
+func TestValidateCredentials(t *testing.T) {
+	v := validator.New()
+	ValidateEmail(v, "alice")
+	ValidatePasswordPlaintext(v, string(make([]byte, 73)))
+	ValidateTokenPlaintext(v, "ABC")
+
+	want := map[string][]validator.FieldError{
+		"email":    {{Code: "email", Message: "must be a valid email address", Params: map[string]string{"kind": "string"}}},
+		"password": {{Code: "max", Message: "must not be more than 72 bytes long", Params: map[string]string{"max": "72", "kind": "string"}}},
+		"token":    {{Code: "len", Message: "must be exactly 26 bytes long", Params: map[string]string{"len": "26", "kind": "string"}}},
+	}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got %v; want %v", v.Errors, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Package i18n holds the message catalogue used to localize validation errors and
+// error responses. Messages are keyed by the same stable codes that are sent to
+// clients, and are written as templates with {name} placeholders for parameters.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The languages we have message bundles for. English is the default and the
+// fallback for any message missing from another bundle.
+const (
+	English = "en"
+	Swahili = "sw"
+	French  = "fr"
+)
+
+// catalogue maps a language to its bundle of message templates. A code can have
+// variants for different kinds of value, written as "code.kind" (e.g. "max.string"
+// and "max.items"), which take precedence over the plain code when the "kind"
+// parameter matches. A "code.kind.one" variant (e.g. "min.items.one") is used instead
+// when the code's own parameter is 1, for languages where the noun is then singular.
+var catalogue = map[string]map[string]string{
+	English: english,
+	Swahili: swahili,
+	French:  french,
+}
+
+// Negotiate picks the best supported language for the value of an Accept-Language
+// header, such as "fr-CH, fr;q=0.9, en;q=0.8". Region subtags are ignored, so "sw-KE"
+// selects Swahili. If none of the requested languages are supported, English is used.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, qvalue, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(qvalue), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogue[base]; ok && q > 0 {
+			candidates = append(candidates, candidate{lang: base, q: q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return English
+	}
+
+	//Use a stable sort so that languages with equal weights keep the order the
+	//client listed them in
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	return candidates[0].lang
+}
+
+// Translate renders the message for a code in the given language, substituting
+// params into the template. It falls back to the English bundle if the language
+// doesn't have the message, and reports false if neither bundle has it.
+func Translate(lang, code string, params map[string]string) (string, bool) {
+	template, ok := lookup(lang, code, params)
+	if !ok {
+		template, ok = lookup(English, code, params)
+		if !ok {
+			return "", false
+		}
+	}
+
+	pairs := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+
+	return strings.NewReplacer(pairs...).Replace(template), true
+}
+
+func lookup(lang, code string, params map[string]string) (string, bool) {
+	bundle, ok := catalogue[lang]
+	if !ok {
+		return "", false
+	}
+
+	if kind := params["kind"]; kind != "" {
+		if params[code] == "1" {
+			if template, ok := bundle[code+"."+kind+".one"]; ok {
+				return template, true
+			}
+		}
+		if template, ok := bundle[code+"."+kind]; ok {
+			return template, true
+		}
+	}
+
+	template, ok := bundle[code]
+	return template, ok
+}
@@ -0,0 +1,52 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", English},
+		{"fr", French},
+		{"sw-KE", Swahili},
+		{"de, fr;q=0.5, sw;q=0.8", Swahili},
+		{"fr;q=0, en;q=0.1", English},
+		{"fr, sw", French},
+		{"de", English},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		lang   string
+		code   string
+		params map[string]string
+		want   string
+	}{
+		{English, "max", map[string]string{"max": "10"}, "must not be greater than 10"},
+		{English, "max", map[string]string{"max": "10", "kind": "string"}, "must not be more than 10 bytes long"},
+		{English, "min", map[string]string{"min": "2", "kind": "items"}, "must contain at least 2 items"},
+		{English, "min", map[string]string{"min": "1", "kind": "items"}, "must contain at least 1 item"},
+		{French, "min", map[string]string{"min": "1", "kind": "items"}, "doit contenir au moins 1 élément"},
+		{Swahili, "required", nil, "lazima itolewe"},
+		{French, "method_not_allowed", map[string]string{"method": "TRACE"}, "la méthode TRACE n'est pas prise en charge pour cette ressource"},
+	}
+
+	for _, tt := range tests {
+		got, ok := Translate(tt.lang, tt.code, tt.params)
+		if !ok || got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q, %t; want %q", tt.lang, tt.code, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := Translate(French, "no_such_code", nil); ok {
+		t.Error("Translate() found a message for an unknown code")
+	}
+}
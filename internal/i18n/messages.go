@@ -0,0 +1,155 @@
+package i18n
+
+// english is the default bundle. Every code sent to clients should have an entry
+// here, since it is the fallback for the other languages.
+var english = map[string]string{
+	// Validation rules.
+	"required":                     "must be provided",
+	"min":                          "must be at least {min}",
+	"min.string":                   "must be at least {min} bytes long",
+	"min.items":                    "must contain at least {min} items",
+	"min.items.one":                "must contain at least {min} item",
+	"max":                          "must not be greater than {max}",
+	"max.string":                   "must not be more than {max} bytes long",
+	"max.items":                    "must not contain more than {max} items",
+	"len":                          "must be exactly {len}",
+	"len.string":                   "must be exactly {len} bytes long",
+	"len.items":                    "must contain exactly {len} items",
+	"oneof":                        "must be one of: {values}",
+	"unique":                       "must not contain duplicate values",
+	"email":                        "must be a valid email address",
+	"not_future":                   "must not be in the future",
+	"positive":                     "must be a positive integer",
+	"integer":                      "must be an integer value",
+	"duplicate":                    "a user with this email address already exists",
+	"invalid_token.activation":     "invalid or expired activation token",
+	"invalid_token.password-reset": "invalid or expired password reset token",
+	"invalid_api_key":              "must be a valid API key",
+	"not_granted":                  "must be a permission you hold",
+	"future":                       "must be in the future",
+
+	// Error responses.
+	"server_error":                 "The server encountered a problem and could not process your request",
+	"not_found":                    "The requested resource could not be found",
+	"method_not_allowed":           "the {method} method is not supported for this resource",
+	"failed_validation":            "one or more fields failed validation",
+	"edit_conflict":                "unable to update the record due to an edit conflict, please try again",
+	"rate_limit_exceeded":          "rate limit exceeded",
+	"invalid_credentials":          "invalid authentication credentials",
+	"invalid_authentication_token": "invalid or missing authentication token",
+	"authentication_required":      "you must be authenticated to access this resource",
+	"inactive_account":             "your user account must be activated to access this resource",
+	"not_permitted":                "your user account doesn't have the necessary permissions to access this resource",
+	"api_key_not_allowed":          "this resource can't be accessed with an API key, please authenticate with a user token instead",
+	"service_unavailable":          "the server is temporarily unable to handle your request, please try again later",
+	"bad_request":                  "the request could not be understood",
+	"body_malformed":               "body contains badly-formed JSON",
+	"body_malformed_at":            "body contains badly-formed JSON (at character {offset})",
+	"body_wrong_type_field":        "body contains incorrect JSON type for field {field}",
+	"body_wrong_type_at":           "body contains incorrect JSON type (at character {offset})",
+	"body_empty":                   "body must not be empty",
+	"body_unknown_key":             "body contains unknown key {key}",
+	"body_too_large":               "body must not be larger than {limit} bytes",
+	"invalid_expected_version":     "the X-Expected-Version header must be a non-negative integer",
+	"api_key_logout":               "API keys must be revoked with DELETE /v1/api-keys/:id",
+}
+
+var swahili = map[string]string{
+	"required":                     "lazima itolewe",
+	"min":                          "lazima iwe angalau {min}",
+	"min.string":                   "lazima iwe na urefu wa angalau baiti {min}",
+	"min.items":                    "lazima iwe na angalau vipengee {min}",
+	"min.items.one":                "lazima iwe na angalau kipengee {min}",
+	"max":                          "haipaswi kuzidi {max}",
+	"max.string":                   "haipaswi kuzidi urefu wa baiti {max}",
+	"max.items":                    "haipaswi kuwa na zaidi ya vipengee {max}",
+	"len":                          "lazima iwe {len} hasa",
+	"len.string":                   "lazima iwe na urefu wa baiti {len} hasa",
+	"len.items":                    "lazima iwe na vipengee {len} hasa",
+	"oneof":                        "lazima iwe mojawapo ya: {values}",
+	"unique":                       "haipaswi kuwa na thamani zinazojirudia",
+	"email":                        "lazima iwe anwani halali ya barua pepe",
+	"not_future":                   "haipaswi kuwa katika siku zijazo",
+	"positive":                     "lazima iwe nambari kamili chanya",
+	"integer":                      "lazima iwe nambari kamili",
+	"duplicate":                    "mtumiaji mwenye anwani hii ya barua pepe tayari yupo",
+	"invalid_token.activation":     "tokeni ya kuwezesha si sahihi au imeisha muda wake",
+	"invalid_token.password-reset": "tokeni ya kuweka upya nenosiri si sahihi au imeisha muda wake",
+	"invalid_api_key":              "lazima iwe ufunguo halali wa API",
+	"not_granted":                  "lazima iwe ruhusa uliyo nayo",
+	"future":                       "lazima iwe katika siku zijazo",
+
+	"server_error":                 "Seva imekumbana na tatizo na haikuweza kushughulikia ombi lako",
+	"not_found":                    "Rasilimali uliyoomba haikupatikana",
+	"method_not_allowed":           "mbinu ya {method} haitumiki kwa rasilimali hii",
+	"failed_validation":            "sehemu moja au zaidi hazikupita uthibitishaji",
+	"edit_conflict":                "imeshindwa kusasisha rekodi kwa sababu ya mgongano wa uhariri, tafadhali jaribu tena",
+	"rate_limit_exceeded":          "kikomo cha maombi kimepitwa",
+	"invalid_credentials":          "vitambulisho vya uthibitishaji si sahihi",
+	"invalid_authentication_token": "tokeni ya uthibitishaji si sahihi au haipo",
+	"authentication_required":      "lazima uthibitishwe ili kufikia rasilimali hii",
+	"inactive_account":             "akaunti yako lazima iwezeshwe ili kufikia rasilimali hii",
+	"not_permitted":                "akaunti yako haina ruhusa zinazohitajika kufikia rasilimali hii",
+	"api_key_not_allowed":          "rasilimali hii haiwezi kufikiwa kwa ufunguo wa API, tafadhali thibitisha kwa tokeni ya mtumiaji badala yake",
+	"service_unavailable":          "seva haiwezi kushughulikia ombi lako kwa sasa, tafadhali jaribu tena baadaye",
+	"bad_request":                  "ombi halikuweza kueleweka",
+	"body_malformed":               "mwili una JSON iliyoharibika",
+	"body_malformed_at":            "mwili una JSON iliyoharibika (kwenye herufi {offset})",
+	"body_wrong_type_field":        "mwili una aina isiyo sahihi ya JSON kwa sehemu {field}",
+	"body_wrong_type_at":           "mwili una aina isiyo sahihi ya JSON (kwenye herufi {offset})",
+	"body_empty":                   "mwili haupaswi kuwa tupu",
+	"body_unknown_key":             "mwili una ufunguo usiojulikana {key}",
+	"body_too_large":               "mwili haupaswi kuzidi baiti {limit}",
+	"invalid_expected_version":     "kichwa cha X-Expected-Version lazima kiwe nambari kamili isiyo hasi",
+	"api_key_logout":               "funguo za API lazima zibatilishwe kwa DELETE /v1/api-keys/:id",
+}
+
+var french = map[string]string{
+	"required":                     "doit être renseigné",
+	"min":                          "doit être au moins {min}",
+	"min.string":                   "doit faire au moins {min} octets",
+	"min.items":                    "doit contenir au moins {min} éléments",
+	"min.items.one":                "doit contenir au moins {min} élément",
+	"max":                          "ne doit pas dépasser {max}",
+	"max.string":                   "ne doit pas dépasser {max} octets",
+	"max.items":                    "ne doit pas contenir plus de {max} éléments",
+	"len":                          "doit être exactement {len}",
+	"len.string":                   "doit faire exactement {len} octets",
+	"len.items":                    "doit contenir exactement {len} éléments",
+	"oneof":                        "doit être l'une des valeurs suivantes : {values}",
+	"unique":                       "ne doit pas contenir de valeurs en double",
+	"email":                        "doit être une adresse e-mail valide",
+	"not_future":                   "ne doit pas être dans le futur",
+	"positive":                     "doit être un entier positif",
+	"integer":                      "doit être un nombre entier",
+	"duplicate":                    "un utilisateur avec cette adresse e-mail existe déjà",
+	"invalid_token.activation":     "jeton d'activation invalide ou expiré",
+	"invalid_token.password-reset": "jeton de réinitialisation du mot de passe invalide ou expiré",
+	"invalid_api_key":              "doit être une clé d'API valide",
+	"not_granted":                  "doit être une autorisation que vous détenez",
+	"future":                       "doit être dans le futur",
+
+	"server_error":                 "Le serveur a rencontré un problème et n'a pas pu traiter votre requête",
+	"not_found":                    "La ressource demandée est introuvable",
+	"method_not_allowed":           "la méthode {method} n'est pas prise en charge pour cette ressource",
+	"failed_validation":            "un ou plusieurs champs sont invalides",
+	"edit_conflict":                "impossible de mettre à jour l'enregistrement en raison d'un conflit de modification, veuillez réessayer",
+	"rate_limit_exceeded":          "limite de requêtes dépassée",
+	"invalid_credentials":          "identifiants d'authentification invalides",
+	"invalid_authentication_token": "jeton d'authentification invalide ou manquant",
+	"authentication_required":      "vous devez être authentifié pour accéder à cette ressource",
+	"inactive_account":             "votre compte doit être activé pour accéder à cette ressource",
+	"not_permitted":                "votre compte ne dispose pas des autorisations nécessaires pour accéder à cette ressource",
+	"api_key_not_allowed":          "cette ressource n'est pas accessible avec une clé d'API, veuillez vous authentifier avec un jeton utilisateur",
+	"service_unavailable":          "le serveur ne peut pas traiter votre requête pour le moment, veuillez réessayer plus tard",
+	"bad_request":                  "la requête n'a pas pu être comprise",
+	"body_malformed":               "le corps contient du JSON mal formé",
+	"body_malformed_at":            "le corps contient du JSON mal formé (au caractère {offset})",
+	"body_wrong_type_field":        "le corps contient un type JSON incorrect pour le champ {field}",
+	"body_wrong_type_at":           "le corps contient un type JSON incorrect (au caractère {offset})",
+	"body_empty":                   "le corps ne doit pas être vide",
+	"body_unknown_key":             "le corps contient une clé inconnue {key}",
+	"body_too_large":               "le corps ne doit pas dépasser {limit} octets",
+	"invalid_expected_version":     "l'en-tête X-Expected-Version doit être un entier positif ou nul",
+	"api_key_logout":               "les clés d'API doivent être révoquées avec DELETE /v1/api-keys/:id",
+}
@@ -0,0 +1,268 @@
+// Package jwtauth issues and verifies the signed JWTs used by the stateless
+// authentication mode. Tokens are signed with HMAC-SHA256 or Ed25519 and carry a
+// "kid" header, so several keys can be trusted at once while the signing key is
+// rotated. A small in-memory revocation list lets logout work without a database.
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned by Verify() for any token which is malformed, signed
+// with an unknown key, expired, not yet valid, meant for another issuer or audience,
+// or revoked. The underlying reason is wrapped for logging.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Key is a named signing key. The ID is sent in the "kid" header of every token the
+// key signs, so Verify() knows which key to check the signature with.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+// NewHMACKey returns an HS256 key. The secret must be at least 32 bytes long.
+func NewHMACKey(id string, secret []byte) (Key, error) {
+	if len(secret) < 32 {
+		return Key{}, fmt.Errorf("jwtauth: HMAC key %q must be at least 32 bytes long", id)
+	}
+	return Key{ID: id, method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key from a 32 byte Ed25519 seed.
+func NewEd25519Key(id string, seed []byte) (Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return Key{}, fmt.Errorf("jwtauth: Ed25519 key %q must be a %d byte seed", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return Key{ID: id, method: jwt.SigningMethodEdDSA, sign: private, verify: private.Public()}, nil
+}
+
+// ParseKeys reads a comma-separated list of keys in the form "kid:alg:base64", where
+// alg is HS256 or EdDSA and the base64 (standard encoding) value is the HMAC secret
+// or the Ed25519 seed. The first key in the list is used for signing new tokens and
+// every key is accepted for verification, so to rotate keys, put the new key first
+// and drop the old one once the tokens it signed have expired.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("jwtauth: key %q is not in the form kid:alg:base64", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwtauth: key %q: %w", parts[0], err)
+		}
+
+		var key Key
+		switch parts[1] {
+		case "HS256":
+			key, err = NewHMACKey(parts[0], material)
+		case "EdDSA":
+			key, err = NewEd25519Key(parts[0], material)
+		default:
+			err = fmt.Errorf("jwtauth: key %q has unsupported algorithm %q", parts[0], parts[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwtauth: no keys configured")
+	}
+
+	return keys, nil
+}
+
+// Subject describes the user a token is issued for. Everything the API needs to
+// authorize a request is copied into the token, so that verifying it doesn't need a
+// database lookup.
+type Subject struct {
+	UserID      int64
+	Version     int
+	Activated   bool
+	Permissions []string
+}
+
+// Claims are the claims carried by our tokens. Version is the user's record version
+// when the token was issued; since a password reset bumps the version, comparing it
+// with the current user record shows whether the token predates the reset. Activated
+// and Permissions are the user's activation status and permission codes at that time.
+type Claims struct {
+	jwt.RegisteredClaims
+	Version     int      `json:"ver"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+}
+
+// UserID returns the user ID from the subject claim.
+func (c *Claims) UserID() (int64, error) {
+	return strconv.ParseInt(c.Subject, 10, 64)
+}
+
+// Manager issues, verifies and revokes tokens for one issuer and audience.
+type Manager struct {
+	signing  Key
+	keys     map[string]Key
+	issuer   string
+	audience string
+	parser   *jwt.Parser
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// New returns a Manager which signs tokens with the first key and verifies tokens
+// signed by any of them.
+func New(keys []Key, issuer, audience string) (*Manager, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwtauth: no keys configured")
+	}
+
+	m := &Manager{
+		signing:  keys[0],
+		keys:     make(map[string]Key, len(keys)),
+		issuer:   issuer,
+		audience: audience,
+		revoked:  make(map[string]time.Time),
+	}
+
+	methods := []string{}
+	for _, key := range keys {
+		if _, exists := m.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwtauth: duplicate key id %q", key.ID)
+		}
+		m.keys[key.ID] = key
+		methods = append(methods, key.method.Alg())
+	}
+
+	//Only accept the algorithms of our configured keys, require an expiry time, and
+	//check the issuer and audience. The nbf claim is checked automatically whenever
+	//it is present. The small leeway allows for clock skew between servers.
+	m.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+	)
+
+	return m, nil
+}
+
+// Issue signs a new token for a user which is valid from now until now+ttl. It
+// returns the signed token and its expiry time.
+func (m *Manager) Issue(subject Subject, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        rand.Text(),
+			Issuer:    m.issuer,
+			Subject:   strconv.FormatInt(subject.UserID, 10),
+			Audience:  jwt.ClaimStrings{m.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiry),
+		},
+		Version:     subject.Version,
+		Activated:   subject.Activated,
+		Permissions: subject.Permissions,
+	}
+
+	token := jwt.NewWithClaims(m.signing.method, claims)
+	token.Header["kid"] = m.signing.ID
+
+	signed, err := token.SignedString(m.signing.sign)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiry, nil
+}
+
+// Verify checks a token's signature and claims and returns the claims if it is valid.
+func (m *Manager) Verify(tokenString string) (*Claims, error) {
+	var claims Claims
+
+	_, err := m.parser.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		//Make sure the token was signed with the algorithm that belongs to this key,
+		//so an HMAC token can't be checked against an Ed25519 public key or vice versa
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q for key %q", t.Method.Alg(), kid)
+		}
+
+		return key.verify, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: missing jti claim", ErrInvalidToken)
+	}
+
+	if m.isRevoked(claims.ID) {
+		return nil, fmt.Errorf("%w: token has been revoked", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+// Revoke adds a token to the revocation list until it expires. The list is held in
+// memory, so it only covers tokens revoked through this process and is lost on restart.
+func (m *Manager) Revoke(claims *Claims) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Drop entries for tokens which have expired anyway, keeping the list small
+	now := time.Now()
+	for id, expiry := range m.revoked {
+		if now.After(expiry) {
+			delete(m.revoked, id)
+		}
+	}
+
+	if claims.ExpiresAt != nil {
+		m.revoked[claims.ID] = claims.ExpiresAt.Time
+	}
+}
+
+func (m *Manager) isRevoked(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.revoked[id]
+	return ok
+}
@@ -0,0 +1,280 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	hmacKey = mustKey(NewHMACKey("h1", bytes.Repeat([]byte("s"), 32)))
+	edKey   = mustKey(NewEd25519Key("e1", bytes.Repeat([]byte("e"), ed25519.SeedSize)))
+)
+
+func mustKey(key Key, err error) Key {
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func newManager(t *testing.T, keys ...Key) *Manager {
+	t.Helper()
+
+	m, err := New(keys, "greenlight", "greenlight-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// sign signs claims with a key, setting the "kid" header to kid, so that tests can
+// build tokens that Issue() never would.
+func sign(t *testing.T, key Key, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key.sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims returns claims which the manager from newManager() accepts.
+func validClaims() *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "greenlight",
+			Subject:   "42",
+			Audience:  jwt.ClaimStrings{"greenlight-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	for _, key := range []Key{hmacKey, edKey} {
+		m := newManager(t, key)
+
+		token, expiry, err := m.Issue(Subject{UserID: 42, Version: 3, Activated: true, Permissions: []string{"movies:read"}}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(expiry); d < 59*time.Minute || d > time.Hour {
+			t.Errorf("%s: got expiry in %s; want in an hour", key.ID, d)
+		}
+
+		claims, err := m.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: %v", key.ID, err)
+		}
+
+		userID, err := claims.UserID()
+		if err != nil || userID != 42 {
+			t.Errorf("%s: got user ID %d, %v; want 42", key.ID, userID, err)
+		}
+		if claims.Version != 3 || !claims.Activated || len(claims.Permissions) != 1 || claims.Permissions[0] != "movies:read" {
+			t.Errorf("%s: got claims %+v", key.ID, claims)
+		}
+		if claims.ID == "" {
+			t.Errorf("%s: got no jti", key.ID)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	m := newManager(t, hmacKey, edKey)
+
+	otherHMAC := mustKey(NewHMACKey("h1", bytes.Repeat([]byte("x"), 32)))
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"malformed", func() string { return "not.a.token" }},
+		{"unknown kid", func() string { return sign(t, hmacKey, "h2", validClaims()) }},
+		{"missing kid", func() string { return sign(t, hmacKey, "", validClaims()) }},
+		{"wrong secret", func() string { return sign(t, otherHMAC, "h1", validClaims()) }},
+		{"alg doesn't match kid", func() string { return sign(t, hmacKey, "e1", validClaims()) }},
+		{"EdDSA token with HMAC kid", func() string { return sign(t, edKey, "h1", validClaims()) }},
+		{"alg none", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+			token.Header["kid"] = "h1"
+			signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}},
+		{"wrong issuer", func() string {
+			c := validClaims()
+			c.Issuer = "someone-else"
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"wrong audience", func() string {
+			c := validClaims()
+			c.Audience = jwt.ClaimStrings{"another-api"}
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"no expiry", func() string {
+			c := validClaims()
+			c.ExpiresAt = nil
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"expired beyond the leeway", func() string {
+			c := validClaims()
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"not yet valid beyond the leeway", func() string {
+			c := validClaims()
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"issued in the future beyond the leeway", func() string {
+			c := validClaims()
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+			return sign(t, hmacKey, "h1", c)
+		}},
+		{"missing jti", func() string {
+			c := validClaims()
+			c.ID = ""
+			return sign(t, hmacKey, "h1", c)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Verify(tt.token())
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v; want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyLeeway(t *testing.T) {
+	m := newManager(t, hmacKey)
+
+	//Clocks a few seconds apart shouldn't make a token invalid
+	c := validClaims()
+	c.IssuedAt = jwt.NewNumericDate(time.Now().Add(10 * time.Second))
+	c.NotBefore = jwt.NewNumericDate(time.Now().Add(10 * time.Second))
+	c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+
+	if _, err := m.Verify(sign(t, hmacKey, "h1", c)); err != nil {
+		t.Errorf("got error %v; want the token to be accepted within the leeway", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newManager(t, hmacKey)
+	token, _, err := old.Issue(Subject{UserID: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//While both keys are configured, new tokens are signed with the first key and
+	//tokens signed with the old one are still accepted
+	rotating := newManager(t, edKey, hmacKey)
+	if _, err := rotating.Verify(token); err != nil {
+		t.Errorf("got error %v for a token signed with the old key", err)
+	}
+
+	fresh, _, err := rotating.Issue(Subject{UserID: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := jwt.NewParser().ParseUnverified(fresh, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := header.Header["kid"]; kid != "e1" {
+		t.Errorf("got kid %v for a new token; want e1", kid)
+	}
+
+	//Once the old key is dropped, its tokens are rejected
+	rotated := newManager(t, edKey)
+	if _, err := rotated.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v after dropping the old key; want ErrInvalidToken", err)
+	}
+	if _, err := rotated.Verify(fresh); err != nil {
+		t.Errorf("got error %v for a token signed with the new key", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	m := newManager(t, hmacKey)
+
+	revoked, _, err := m.Issue(Subject{UserID: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, _, err := m.Issue(Subject{UserID: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := m.Verify(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Revoke(claims)
+
+	if _, err := m.Verify(revoked); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v for a revoked token; want ErrInvalidToken", err)
+	}
+	if _, err := m.Verify(kept); err != nil {
+		t.Errorf("got error %v for another token; want it to be unaffected", err)
+	}
+
+	//Entries for tokens which have expired are dropped when another token is revoked
+	m.revoked["expired"] = time.Now().Add(-time.Minute)
+	m.Revoke(claims)
+	if _, ok := m.revoked["expired"]; ok {
+		t.Error("got an expired entry left in the revocation list")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("s"), 32))
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("e"), ed25519.SeedSize))
+
+	keys, err := ParseKeys("new:EdDSA:" + seed + ", old:HS256:" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" {
+		t.Errorf("got keys %v; want new and old", keys)
+	}
+
+	for _, spec := range []string{
+		"",
+		"k1:HS256",
+		":HS256:" + secret,
+		"k1:RS256:" + secret,
+		"k1:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:EdDSA:" + secret[:8],
+		"k1:HS256:not base64!",
+	} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q): got no error", spec)
+		}
+	}
+
+	if _, err := New([]Key{hmacKey, hmacKey}, "greenlight", "greenlight-api"); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("got error %v for duplicate key IDs", err)
+	}
+}
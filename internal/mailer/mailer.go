@@ -0,0 +1,247 @@
+// Package mailer renders the application's email templates and delivers them. The
+// Mailer interface lets main() pick a real SMTP server in production and an
+// in-memory stand-in for development and tests.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	ht "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer is implemented by anything that can send one of our email templates to a
+// recipient. The data is passed to the template when it is executed.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Message is a rendered email, ready to be delivered.
+type Message struct {
+	Recipient string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// render executes the "subject", "plainBody" and "htmlBody" templates from the given
+// template file. The plain text parts use text/template and the HTML part uses
+// html/template, so values interpolated into the HTML are escaped.
+func render(recipient, templateFile string, data any) (Message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return Message{}, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	htmlTmpl, err := ht.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return Message{}, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Recipient: recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// SMTP sends email through an SMTP server. If no username is configured it connects
+// without authentication, which suits local stand-ins such as MailHog or Mailpit.
+type SMTP struct {
+	addr     string
+	auth     smtp.Auth
+	sender   string
+	attempts int
+}
+
+// NewSMTP returns an SMTP mailer for the server at host:port, sending from the given
+// sender address (e.g. "Greenlight <no-reply@greenlight.example.com>").
+func NewSMTP(host string, port int, username, password, sender string) SMTP {
+	m := SMTP{
+		addr:     host + ":" + strconv.Itoa(port),
+		sender:   sender,
+		attempts: 3,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send renders the template and delivers the email. Sending is retried up to three
+// times, sleeping for 500 milliseconds between attempts, since SMTP servers often fail
+// transiently.
+func (m SMTP) Send(recipient, templateFile string, data any) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := m.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	address, err := mailAddress(m.sender)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= m.attempts; i++ {
+		err = smtp.SendMail(m.addr, m.auth, address, []string{recipient}, body)
+		if err == nil {
+			return nil
+		}
+
+		if i != m.attempts {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return err
+}
+
+// encode builds a multipart/alternative MIME message containing the plain text and
+// HTML bodies.
+func (m SMTP) encode(msg Message) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.PlainBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = pw.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", m.sender)
+	fmt.Fprintf(&out, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&out, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	out.Write(body.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// mailAddress extracts the bare address from a sender such as
+// "Greenlight <no-reply@greenlight.example.com>" for use in the SMTP envelope.
+func mailAddress(sender string) (string, error) {
+	addr, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
+
+// Memory keeps rendered emails in memory instead of delivering them. It is meant for
+// development, where there's no SMTP server, and for tests which need to inspect what
+// would have been sent. Only the most recent messages are kept, so a long-running
+// process doesn't grow without bound, and each message is also written to the logger
+// (if there is one) so that activation and password reset tokens can be read from the
+// logs.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+	limit    int
+	logger   *slog.Logger
+}
+
+// NewMemory returns an empty in-memory mailer which keeps up to limit messages,
+// discarding the oldest when it is full. The logger may be nil.
+func NewMemory(limit int, logger *slog.Logger) *Memory {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Memory{limit: limit, logger: logger}
+}
+
+// Send renders the template and records the resulting message.
+func (m *Memory) Send(recipient, templateFile string, data any) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	if m.logger != nil {
+		m.logger.Info("email not sent, no SMTP server configured",
+			"recipient", msg.Recipient, "subject", msg.Subject, "body", msg.PlainBody)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == m.limit {
+		copy(m.messages, m.messages[1:])
+		m.messages = m.messages[:m.limit-1]
+	}
+	m.messages = append(m.messages, msg)
+
+	return nil
+}
+
+// Messages returns a copy of the messages kept, oldest first.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,212 @@
+// Package metrics collects request counts, response statuses and per-route processing
+// times for the API, along with any gauges and counters registered by the application
+// (such as database pool statistics), and renders them in the Prometheus text format.
+// The same values are available as a map for publishing through expvar.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the processing time histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into cumulative buckets, as Prometheus expects.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// gauge is a value which is read when the metrics are rendered. Its kind is the
+// Prometheus metric type, "gauge" or "counter".
+type gauge struct {
+	name string
+	kind string
+	help string
+	fn   func() float64
+}
+
+// route identifies a histogram by request method and route pattern.
+type route struct {
+	method  string
+	pattern string
+}
+
+// Metrics holds the application's metrics. It is safe for concurrent use.
+type Metrics struct {
+	buckets []float64
+
+	mu               sync.Mutex
+	requests         int64
+	responses        int64
+	inFlight         int64
+	statuses         map[int]int64
+	durations        map[route]*histogram
+	gauges           []gauge
+	version          string
+	processStartedAt time.Time
+}
+
+// New returns an empty set of metrics for the given application version.
+func New(version string) *Metrics {
+	return &Metrics{
+		buckets:          DefaultBuckets,
+		statuses:         make(map[int]int64),
+		durations:        make(map[route]*histogram),
+		version:          version,
+		processStartedAt: time.Now(),
+	}
+}
+
+// GaugeFunc registers a gauge whose value is read by calling fn each time the metrics
+// are rendered. The name should follow the Prometheus naming conventions, e.g.
+// "greenlight_db_open_connections".
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gauges = append(m.gauges, gauge{name: name, kind: "gauge", help: help, fn: fn})
+}
+
+// CounterFunc registers a counter whose value is read by calling fn each time the
+// metrics are rendered. fn must return a value which only ever increases, such as a
+// running total kept elsewhere, and the name should end in "_total".
+func (m *Metrics) CounterFunc(name, help string, fn func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gauges = append(m.gauges, gauge{name: name, kind: "counter", help: help, fn: fn})
+}
+
+// Start records that a request has been received. It must be followed by a call to
+// Observe() once the response has been sent.
+func (m *Metrics) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests++
+	m.inFlight++
+}
+
+// Observe records the status code and processing time of a response, against the
+// request method and the route pattern (e.g. "/v1/movies/:id") which handled it.
+func (m *Metrics) Observe(method, pattern string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+	m.responses++
+	m.statuses[status]++
+
+	key := route{method: method, pattern: pattern}
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(m.buckets))}
+		m.durations[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, upper := range m.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Snapshot returns the request and response metrics as a map, in a shape suited to
+// publishing with expvar.
+func (m *Metrics) Snapshot() any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make(map[string]int64, len(m.statuses))
+	for status, count := range m.statuses {
+		statuses[strconv.Itoa(status)] = count
+	}
+
+	routes := make(map[string]any, len(m.durations))
+	for key, h := range m.durations {
+		routes[key.method+" "+key.pattern] = map[string]any{
+			"count":                    h.count,
+			"total_processing_time_us": int64(h.sum * 1e6),
+		}
+	}
+
+	return map[string]any{
+		"total_requests_received":        m.requests,
+		"total_responses_sent":           m.responses,
+		"in_flight_requests":             m.inFlight,
+		"total_responses_sent_by_status": statuses,
+		"processing_time_by_route":       routes,
+		"process_uptime_seconds":         int64(time.Since(m.processStartedAt).Seconds()),
+	}
+}
+
+// WritePrometheus writes every metric to w in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	gauges := slices.Clone(m.gauges)
+
+	var b strings.Builder
+
+	writeHeader(&b, "greenlight_build_info", "gauge", "Build information, with the application version as a label")
+	fmt.Fprintf(&b, "greenlight_build_info{version=%q} 1\n", m.version)
+
+	writeHeader(&b, "greenlight_http_requests_total", "counter", "Total number of HTTP requests received")
+	fmt.Fprintf(&b, "greenlight_http_requests_total %d\n", m.requests)
+
+	writeHeader(&b, "greenlight_http_requests_in_flight", "gauge", "Number of HTTP requests currently being processed")
+	fmt.Fprintf(&b, "greenlight_http_requests_in_flight %d\n", m.inFlight)
+
+	writeHeader(&b, "greenlight_http_responses_total", "counter", "Total number of HTTP responses sent, by status code")
+	for _, status := range slices.Sorted(maps.Keys(m.statuses)) {
+		fmt.Fprintf(&b, "greenlight_http_responses_total{code=\"%d\"} %d\n", status, m.statuses[status])
+	}
+
+	writeHeader(&b, "greenlight_http_request_duration_seconds", "histogram", "Time taken to process HTTP requests, by route")
+	keys := slices.SortedFunc(maps.Keys(m.durations), func(a, b route) int {
+		return strings.Compare(a.pattern+" "+a.method, b.pattern+" "+b.method)
+	})
+	for _, key := range keys {
+		h := m.durations[key]
+		labels := fmt.Sprintf("method=%q,route=%q", key.method, key.pattern)
+		for i, upper := range m.buckets {
+			fmt.Fprintf(&b, "greenlight_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(upper), h.counts[i])
+		}
+		fmt.Fprintf(&b, "greenlight_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "greenlight_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(&b, "greenlight_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeHeader(&b, "greenlight_process_uptime_seconds", "gauge", "Number of seconds since the process started")
+	fmt.Fprintf(&b, "greenlight_process_uptime_seconds %s\n", formatFloat(time.Since(m.processStartedAt).Seconds()))
+	m.mu.Unlock()
+
+	//Read the gauges without holding the lock, since their functions may be slow or
+	//call back into the application
+	for _, g := range gauges {
+		writeHeader(&b, g.name, g.kind, g.help)
+		fmt.Fprintf(&b, "%s %s\n", g.name, formatFloat(g.fn()))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := New("1.2.3")
+	m.GaugeFunc("test_connections", "Open connections", func() float64 { return 3 })
+	m.CounterFunc("test_waits_total", "Total waits", func() float64 { return 7 })
+
+	m.Start()
+	m.Observe("GET", "/v1/movies/:id", 200, 20*time.Millisecond)
+
+	var b strings.Builder
+	err := m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`greenlight_build_info{version="1.2.3"} 1`,
+		"greenlight_http_requests_total 1",
+		"greenlight_http_requests_in_flight 0",
+		`greenlight_http_responses_total{code="200"} 1`,
+		`greenlight_http_request_duration_seconds_bucket{method="GET",route="/v1/movies/:id",le="0.01"} 0`,
+		`greenlight_http_request_duration_seconds_bucket{method="GET",route="/v1/movies/:id",le="0.025"} 1`,
+		`greenlight_http_request_duration_seconds_count{method="GET",route="/v1/movies/:id"} 1`,
+		"# TYPE test_connections gauge\ntest_connections 3\n",
+		"# TYPE test_waits_total counter\ntest_waits_total 7\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, b.String())
+		}
+	}
+}
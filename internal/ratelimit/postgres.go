@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Postgres keeps one token bucket per client in the rate_limits table, so that every
+// API server using the same database shares the same limits. Each call to Allow() is
+// a single atomic upsert, which refills the bucket for the time since it was last
+// used and then takes a token from it if there is one.
+type Postgres struct {
+	db      *sql.DB
+	rps     float64
+	burst   int
+	timeout time.Duration
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPostgres returns a limiter which keeps its buckets in the rate_limits table and
+// allows each client an average of rps requests per second, with bursts of up to
+// burst requests. Each query is given up to timeout to complete; a zero timeout means
+// queries are only bounded by the caller's context. Every minute it
+// deletes the buckets of clients which haven't made a request for maxIdle, until
+// Stop() is called.
+func NewPostgres(db *sql.DB, rps float64, burst int, maxIdle, timeout time.Duration) *Postgres {
+	p := &Postgres{
+		db:      db,
+		rps:     rps,
+		burst:   burst,
+		timeout: timeout,
+		done:    make(chan struct{}),
+	}
+
+	go every(time.Minute, p.done, func() { p.evict(maxIdle) })
+
+	return p
+}
+
+// Stop stops the background eviction of idle clients. It is safe to call more than
+// once.
+func (p *Postgres) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// refilled is the number of tokens in an existing bucket once it has been topped up
+// for the time since it was last used, capped at the burst size ($3).
+const refilled = `LEAST($3::double precision, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * $2::double precision)`
+
+// allowQuery creates a full bucket for a new client, less the token for this request.
+// For an existing client it refills the bucket and takes a token if there is a whole
+// one left. All of the SET expressions see the row as it was before the update.
+var allowQuery = strings.NewReplacer("{refilled}", refilled).Replace(`
+	INSERT INTO rate_limits (key, tokens, allowed, updated_at)
+	VALUES ($1, $3::double precision - 1, true, now())
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE WHEN {refilled} >= 1 THEN {refilled} - 1 ELSE {refilled} END,
+		allowed = {refilled} >= 1,
+		updated_at = now()
+	RETURNING tokens, allowed`)
+
+// Allow takes a token from the client's bucket, creating the bucket if this is the
+// client's first request.
+func (p *Postgres) Allow(ctx context.Context, key string) (Result, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+
+	err := p.db.QueryRowContext(ctx, allowQuery, key, p.rps, p.burst).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens = math.Max(tokens, 0)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     p.burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     timeToRefill(float64(p.burst)-tokens, p.rps),
+	}
+	if !allowed {
+		result.RetryAfter = timeToRefill(1-tokens, p.rps)
+	}
+
+	return result, nil
+}
+
+// evict deletes the buckets which haven't been used for maxIdle. Errors are ignored,
+// since the rows will be deleted on a later run instead.
+func (p *Postgres) evict(maxIdle time.Duration) {
+	ctx, cancel := p.withTimeout(context.Background())
+	defer cancel()
+
+	query := `
+	DELETE FROM rate_limits
+	WHERE updated_at < now() - make_interval(secs => $1)
+	`
+
+	p.db.ExecContext(ctx, query, maxIdle.Seconds())
+}
+
+// withTimeout returns a context for a query which is canceled after the limiter's
+// timeout, or ctx itself when no timeout is set.
+func (p *Postgres) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
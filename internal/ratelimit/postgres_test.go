@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// stubConnector is a database/sql connector whose connections pass every query to
+// query, so that the Postgres limiter can be tested without a database.
+type stubConnector struct {
+	query func(ctx context.Context, args []driver.NamedValue) ([]driver.Value, error)
+}
+
+func (c stubConnector) Connect(context.Context) (driver.Conn, error) { return stubConn{c}, nil }
+func (c stubConnector) Driver() driver.Driver                        { return nil }
+
+type stubConn struct{ stubConnector }
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c stubConn) Close() error                        { return nil }
+func (c stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	row, err := c.query(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &stubRows{row: row}, nil
+}
+
+func (c stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, err := c.query(ctx, args)
+	return driver.RowsAffected(0), err
+}
+
+type stubRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *stubRows) Columns() []string { return []string{"tokens", "allowed"} }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+func TestPostgresTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second, time.Minute} {
+		var deadlines []bool
+
+		db := sql.OpenDB(stubConnector{query: func(ctx context.Context, args []driver.NamedValue) ([]driver.Value, error) {
+			//A query shouldn't be given a context which has already expired
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			_, ok := ctx.Deadline()
+			deadlines = append(deadlines, ok)
+			return []driver.Value{1.0, true}, nil
+		}})
+		defer db.Close()
+
+		p := NewPostgres(db, 1, 2, time.Minute, timeout)
+		p.Stop()
+
+		result, err := p.Allow(context.Background(), "a")
+		if err != nil {
+			t.Fatalf("timeout %s: %v", timeout, err)
+		}
+		if !result.Allowed {
+			t.Errorf("timeout %s: got %+v; want allowed", timeout, result)
+		}
+
+		p.evict(time.Minute)
+
+		want := timeout > 0
+		if len(deadlines) != 2 || deadlines[0] != want || deadlines[1] != want {
+			t.Errorf("timeout %s: got deadlines %v for Allow and evict; want %t", timeout, deadlines, want)
+		}
+	}
+}
+
+func TestPostgresResult(t *testing.T) {
+	tests := []struct {
+		tokens  float64
+		allowed bool
+		want    Result
+	}{
+		{1.5, true, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: 250 * time.Millisecond}},
+		{0.5, false, Result{Allowed: false, Limit: 2, Remaining: 0, Reset: 750 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{-0.5, false, Result{Allowed: false, Limit: 2, Remaining: 0, Reset: time.Second, RetryAfter: 500 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		db := sql.OpenDB(stubConnector{query: func(ctx context.Context, args []driver.NamedValue) ([]driver.Value, error) {
+			return []driver.Value{tt.tokens, tt.allowed}, nil
+		}})
+		defer db.Close()
+
+		p := NewPostgres(db, 2, 2, time.Minute, time.Second)
+		p.Stop()
+
+		got, err := p.Allow(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v tokens: got %+v; want %+v", tt.tokens, got, tt.want)
+		}
+	}
+
+	//Errors from the database are passed on, so the middleware can decide what to do
+	db := sql.OpenDB(stubConnector{query: func(ctx context.Context, args []driver.NamedValue) ([]driver.Value, error) {
+		return nil, errors.New("connection refused")
+	}})
+	defer db.Close()
+
+	p := NewPostgres(db, 2, 2, time.Minute, time.Second)
+	p.Stop()
+
+	if _, err := p.Allow(context.Background(), "a"); err == nil {
+		t.Error("got no error from a failing database")
+	}
+}
+
+// TestPostgresAllow runs the limiter against a real database, given by the
+// GREENLIGHT_TEST_DB_DSN environment variable, and is skipped without one.
+func TestPostgresAllow(t *testing.T) {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migration, err := os.ReadFile(filepath.Join("..", "..", "migrations", "postgres", "000009_create_rate_limits_table.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(migration)); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "test:" + t.Name() + ":" + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() { db.Exec("DELETE FROM rate_limits WHERE key LIKE $1", key+"%") })
+
+	p := NewPostgres(db, 1, 2, time.Minute, 0)
+	defer p.Stop()
+
+	ctx := context.Background()
+
+	for i, wantRemaining := range []int{1, 0} {
+		result, err := p.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != wantRemaining || result.Limit != 2 {
+			t.Fatalf("request %d: got %+v; want allowed with %d remaining", i+1, result, wantRemaining)
+		}
+	}
+
+	result, err := p.Allow(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatalf("request 3: got %+v; want it to be refused", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("got RetryAfter %s; want up to 1s", result.RetryAfter)
+	}
+
+	//Other clients have their own buckets
+	if result, err := p.Allow(ctx, key+":b"); err != nil || !result.Allowed {
+		t.Errorf("got %+v, %v for a new client; want it to be allowed", result, err)
+	}
+
+	//Idle buckets are deleted
+	if _, err := db.Exec("UPDATE rate_limits SET updated_at = now() - interval '2 minutes' WHERE key = $1", key); err != nil {
+		t.Fatal(err)
+	}
+	p.evict(time.Minute)
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM rate_limits WHERE key LIKE $1", key+"%").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d buckets after evicting; want only the active one", count)
+	}
+}
@@ -0,0 +1,143 @@
+// Package ratelimit implements per-client token-bucket rate limiting. The Limiter
+// interface lets main() choose where the buckets are kept: Memory keeps them in the
+// process, which is enough for a single API server, and Postgres keeps them in a
+// database table so that several API servers share the same limits.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Result describes the outcome of a call to Allow(). Limit is the bucket size,
+// Remaining is the number of requests the client can still make straight away, and
+// Reset is how long it will take for the bucket to fill up again. When the request
+// isn't allowed, RetryAfter is how long the client must wait for the next token.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Limiter is implemented by anything that can decide whether the client identified by
+// key may make another request. Stop releases any background resources, such as the
+// goroutine which evicts idle clients.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+	Stop()
+}
+
+// client holds the token bucket for one client and the last time it was used.
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Memory keeps one token bucket per client in memory. Buckets for clients that haven't
+// been seen for a while are evicted in the background so the map doesn't grow forever.
+type Memory struct {
+	rps   rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*client
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemory returns an in-memory limiter which allows each client an average of rps
+// requests per second, with bursts of up to burst requests. Every minute it evicts
+// clients which haven't made a request for maxIdle, until Stop() is called.
+func NewMemory(rps float64, burst int, maxIdle time.Duration) *Memory {
+	m := &Memory{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*client),
+		done:    make(chan struct{}),
+	}
+
+	go every(time.Minute, m.done, func() { m.evict(maxIdle) })
+
+	return m
+}
+
+// Stop stops the background eviction of idle clients. It is safe to call more than
+// once.
+func (m *Memory) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// Allow takes a token from the client's bucket, creating the bucket if this is the
+// client's first request. It never returns an error.
+func (m *Memory) Allow(_ context.Context, key string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(m.rps, m.burst)}
+		m.clients[key] = c
+	}
+
+	now := time.Now()
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+	tokens := math.Max(c.limiter.TokensAt(now), 0)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     m.burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     m.timeToRefill(float64(m.burst) - tokens),
+	}
+	if !allowed {
+		result.RetryAfter = m.timeToRefill(1 - tokens)
+	}
+
+	return result, nil
+}
+
+// timeToRefill returns how long it takes for the given number of tokens to be added
+// back to a bucket.
+func (m *Memory) timeToRefill(tokens float64) time.Duration {
+	return timeToRefill(tokens, float64(m.rps))
+}
+
+func timeToRefill(tokens, rps float64) time.Duration {
+	return time.Duration(tokens / rps * float64(time.Second))
+}
+
+// every calls fn at the given interval until done is closed.
+func every(interval time.Duration, done <-chan struct{}, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-done:
+			return
+		}
+	}
+}
+
+// evict removes the clients which haven't been seen for maxIdle.
+func (m *Memory) evict(maxIdle time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.clients {
+		if time.Since(c.lastSeen) > maxIdle {
+			delete(m.clients, key)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	m := NewMemory(1, 2, time.Minute)
+	defer m.Stop()
+
+	ctx := context.Background()
+
+	for i, wantRemaining := range []int{1, 0} {
+		result, err := m.Allow(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != wantRemaining || result.Limit != 2 {
+			t.Fatalf("request %d: got %+v; want allowed with %d remaining", i+1, result, wantRemaining)
+		}
+	}
+
+	result, _ := m.Allow(ctx, "a")
+	if result.Allowed {
+		t.Fatalf("request 3: got %+v; want it to be refused", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("got RetryAfter %s; want up to 1s", result.RetryAfter)
+	}
+
+	//Other clients have their own buckets
+	if result, _ := m.Allow(ctx, "b"); !result.Allowed {
+		t.Errorf("got %+v for a new client; want it to be allowed", result)
+	}
+}
+
+func TestMemoryEvict(t *testing.T) {
+	m := NewMemory(1, 1, time.Minute)
+	m.Stop()
+	m.Stop()
+
+	m.Allow(context.Background(), "a")
+	m.clients["a"].lastSeen = time.Now().Add(-2 * time.Minute)
+	m.Allow(context.Background(), "b")
+
+	m.evict(time.Minute)
+
+	if _, ok := m.clients["a"]; ok {
+		t.Error("idle client was not evicted")
+	}
+	if _, ok := m.clients["b"]; !ok {
+		t.Error("active client was evicted")
+	}
+}
@@ -0,0 +1,10 @@
+-- Token buckets for the postgres rate limiter backend. The table is unlogged since
+-- losing the buckets in a crash only resets everyone's limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    allowed bool NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_updated_at_idx ON rate_limits (updated_at);
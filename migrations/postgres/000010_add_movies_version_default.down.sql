@@ -0,0 +1 @@
+ALTER TABLE movies ALTER COLUMN version DROP DEFAULT;
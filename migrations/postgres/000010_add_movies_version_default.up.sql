@@ -0,0 +1,6 @@
+-- Movies start at version 1, like users do. Movies inserted before the default was
+-- added were given version 0, so move them on to 1; any client still holding
+-- version 0 gets an edit conflict and has to fetch the movie again.
+ALTER TABLE movies ALTER COLUMN version SET DEFAULT 1;
+
+UPDATE movies SET version = 1 WHERE version = 0;
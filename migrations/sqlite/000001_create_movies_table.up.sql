@@ -0,0 +1,12 @@
+-- Genres are stored as a JSON array of strings, e.g. '["drama","romance"]'.
+-- The year can't be checked against the current date here, since SQLite doesn't
+-- allow non-deterministic functions in CHECK constraints.
+CREATE TABLE IF NOT EXISTS movies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL,
+    year INTEGER NOT NULL CHECK (year >= 1888),
+    runtime INTEGER NOT NULL CHECK (runtime >= 0),
+    genres TEXT NOT NULL CHECK (json_valid(genres) AND json_array_length(genres) BETWEEN 1 AND 5),
+    version INTEGER NOT NULL DEFAULT 1
+);
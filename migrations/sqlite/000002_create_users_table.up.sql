@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    name TEXT NOT NULL,
+    email TEXT UNIQUE NOT NULL COLLATE NOCASE,
+    password_hash BLOB NOT NULL,
+    activated BOOLEAN NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
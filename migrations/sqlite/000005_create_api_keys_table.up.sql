@@ -0,0 +1,14 @@
+-- Permissions are stored as a JSON array of strings, e.g. '["movies:read"]'.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    label TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    hash BLOB UNIQUE NOT NULL,
+    permissions TEXT NOT NULL CHECK (json_valid(permissions)),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    expiry TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
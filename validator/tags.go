@@ -0,0 +1,363 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rule describes a single validation rule which can be referenced by name from a
+// `validate` struct tag. Check reports whether the field value passes the rule and
+// Message builds the error message recorded when it doesn't. Both receive the
+// parameter written after the "=" in the tag (e.g. "500" in "max=500"), or an empty
+// string if there isn't one. Pointer fields are dereferenced before either is called.
+type Rule struct {
+	Check   func(field reflect.Value, param string) bool
+	Message func(field reflect.Value, param string) string
+}
+
+// The rules registry maps a tag name to its Rule. It is pre-populated with the
+// built-in rules below and can be extended with RegisterRule().
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"required": {Check: checkRequired, Message: fixed("must be provided")},
+		"min":      {Check: checkMin, Message: minMessage},
+		"max":      {Check: checkMax, Message: maxMessage},
+		"len":      {Check: checkLen, Message: lenMessage},
+		"oneof":    {Check: checkOneOf, Message: oneOfMessage},
+		"unique":   {Check: checkUnique, Message: fixed("must not contain duplicate values")},
+		"email":    {Check: checkEmail, Message: fixed("must be a valid email address")},
+		"positive": {Check: checkPositive, Message: fixed("must be a positive integer")},
+	}
+)
+
+// RegisterRule adds a custom rule to the registry so that it can be used in struct
+// tags, replacing any existing rule with the same name. It is intended to be called
+// from init() functions or at program start-up.
+func RegisterRule(name string, rule Rule) {
+	if name == "" || name == "dive" || strings.ContainsAny(name, ",=") {
+		panic(fmt.Sprintf("validator: invalid rule name %q", name))
+	}
+	if rule.Check == nil || rule.Message == nil {
+		panic(fmt.Sprintf("validator: rule %q must have a Check and a Message function", name))
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule
+}
+
+func lookupRule(name string) Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	rule, ok := rules[name]
+	if !ok {
+		//An unknown rule name is a bug in the struct tag rather than bad input, so
+		//we panic instead of reporting it as a validation error
+		panic(fmt.Sprintf("validator: unknown rule %q", name))
+	}
+	return rule
+}
+
+// Struct checks every field of the struct pointed to (or contained in) s against the
+// rules in its `validate` tag, adding an error to the map for each failure with the
+// rule name as its code. Errors are keyed by the field's JSON name, falling back to
+// the Go field name.
+//
+// Nested structs are validated recursively, with their errors keyed by a pointer
+// path such as "director/name" (see Pointer). The special "dive" rule applies the
+// rules which follow it to each element of a slice or array, with errors keyed as
+// "genres/2". For example:
+//
+//	Title  string   `json:"title" validate:"required,max=500"`
+//	Genres []string `json:"genres" validate:"required,min=1,max=5,unique,dive,required,max=50"`
+func (v *Validator) Struct(s any) {
+	val := reflect.ValueOf(s)
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct() called with non-struct type %T", s))
+	}
+
+	v.validateStruct(val, "")
+}
+
+// Var checks a single value against the rules in tag, written as they would be in a
+// `validate` struct tag, and records any errors under key. It is for values which
+// aren't held in a struct, and for rules whose parameter is only known at run time,
+// such as the sort values a particular endpoint accepts:
+//
+//	v.Var(sort, "sort", "oneof="+strings.Join(safelist, " "))
+func (v *Validator) Var(value any, key, tag string) {
+	v.validateValue(reflect.ValueOf(value), key, splitRules(tag))
+}
+
+func (v *Validator) validateStruct(val reflect.Value, prefix string) {
+	typ := val.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+
+		key := fieldName(field)
+		if prefix != "" {
+			key = prefix + "/" + pointerEscaper.Replace(key)
+		}
+		v.validateValue(val.Field(i), key, splitRules(tag))
+	}
+}
+
+// validateValue applies the given rules to a single value, then descends into it if
+// it is a struct or if a "dive" rule asked us to check each element.
+func (v *Validator) validateValue(field reflect.Value, key string, tagRules []string) {
+	for i, spec := range tagRules {
+		name, param, _ := strings.Cut(spec, "=")
+
+		if name == "dive" {
+			v.dive(field, key, tagRules[i+1:])
+			return
+		}
+
+		//Only "required" looks at a nil pointer. The other rules only make sense
+		//when there is a value to check, so they are skipped for nil pointers.
+		value, ok := indirect(field)
+		if name == "required" {
+			value = field
+		} else if !ok {
+			continue
+		}
+
+		rule := lookupRule(name)
+		if !rule.Check(value, param) {
+			v.AddError(key, name, rule.Message(value, param), ruleParams(value, name, param)...)
+		}
+	}
+
+	if value, ok := indirect(field); ok && value.Kind() == reflect.Struct && value.NumField() > 0 {
+		v.validateStruct(value, key)
+	}
+}
+
+func (v *Validator) dive(field reflect.Value, key string, tagRules []string) {
+	value, ok := indirect(field)
+	if !ok {
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			v.validateValue(value.Index(i), fmt.Sprintf("%s/%d", key, i), tagRules)
+		}
+	default:
+		panic(fmt.Sprintf("validator: dive used on non-slice field %q", key))
+	}
+}
+
+// ruleParams returns the name/value pairs recorded alongside a failed rule. The
+// rule's own parameter is stored under the rule name (e.g. "max": "500"), and "kind"
+// says whether a size applies to a string or to a collection of items, because the
+// wording of the message differs between the two.
+func ruleParams(field reflect.Value, name, param string) []string {
+	var params []string
+
+	switch {
+	case name == "oneof":
+		params = append(params, "values", strings.Join(strings.Fields(param), ", "))
+	case param != "":
+		params = append(params, name, param)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		params = append(params, "kind", "string")
+	case reflect.Slice, reflect.Array, reflect.Map:
+		params = append(params, "kind", "items")
+	}
+
+	return params
+}
+
+// fieldName returns the name used for a field in error keys: the name from its json
+// tag if it has one, otherwise the Go field name.
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func splitRules(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// indirect follows pointers until it reaches a non-pointer value. The boolean is
+// false if a nil pointer was found along the way.
+func indirect(field reflect.Value) (reflect.Value, bool) {
+	for field.Kind() == reflect.Pointer || field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return field, false
+		}
+		field = field.Elem()
+	}
+	return field, true
+}
+
+func fixed(message string) func(reflect.Value, string) string {
+	return func(reflect.Value, string) string { return message }
+}
+
+func checkRequired(field reflect.Value, _ string) bool {
+	return !field.IsZero()
+}
+
+// size returns the quantity that min, max and len compare against: the length of a
+// string in bytes, the number of elements in a slice, array or map, or the numeric
+// value itself.
+func size(field reflect.Value) float64 {
+	switch field.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(field.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(field.Uint())
+	case reflect.Float32, reflect.Float64:
+		return field.Float()
+	default:
+		panic(fmt.Sprintf("validator: cannot measure the size of a %s", field.Kind()))
+	}
+}
+
+func mustParam(param string) float64 {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: invalid numeric rule parameter %q", param))
+	}
+	return n
+}
+
+func checkMin(field reflect.Value, param string) bool {
+	return size(field) >= mustParam(param)
+}
+
+func checkMax(field reflect.Value, param string) bool {
+	return size(field) <= mustParam(param)
+}
+
+func checkLen(field reflect.Value, param string) bool {
+	return size(field) == mustParam(param)
+}
+
+func minMessage(field reflect.Value, param string) string {
+	switch field.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be at least %s bytes long", param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if param == "1" {
+			return "must contain at least 1 item"
+		}
+		return fmt.Sprintf("must contain at least %s items", param)
+	default:
+		return fmt.Sprintf("must be at least %s", param)
+	}
+}
+
+func maxMessage(field reflect.Value, param string) string {
+	switch field.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must not be more than %s bytes long", param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("must not contain more than %s items", param)
+	default:
+		return fmt.Sprintf("must not be greater than %s", param)
+	}
+}
+
+func lenMessage(field reflect.Value, param string) string {
+	switch field.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be exactly %s bytes long", param)
+	default:
+		return fmt.Sprintf("must contain exactly %s items", param)
+	}
+}
+
+// checkOneOf compares the field, formatted as a string, against a space-separated
+// list of permitted values, e.g. `validate:"oneof=asc desc"`.
+func checkOneOf(field reflect.Value, param string) bool {
+	return PermittedValue(fmt.Sprint(field.Interface()), strings.Fields(param)...)
+}
+
+func oneOfMessage(_ reflect.Value, param string) string {
+	return "must be one of: " + strings.Join(strings.Fields(param), ", ")
+}
+
+// checkUnique reports whether a slice or array has no repeated elements. Comparable
+// elements are checked with a map; if any element isn't comparable (such as a slice,
+// a map, or an interface holding one) we fall back to comparing every pair with
+// reflect.DeepEqual, since using it as a map key would panic.
+func checkUnique(field reflect.Value, _ string) bool {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Array:
+	default:
+		panic(fmt.Sprintf("validator: unique used on a %s", field.Kind()))
+	}
+
+	allComparable := true
+	for i := 0; i < field.Len(); i++ {
+		if !field.Index(i).Comparable() {
+			allComparable = false
+			break
+		}
+	}
+
+	if !allComparable {
+		for i := 0; i < field.Len(); i++ {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(field.Index(i).Interface(), field.Index(j).Interface()) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	seen := make(map[any]bool, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		elem := field.Index(i).Interface()
+		if seen[elem] {
+			return false
+		}
+		seen[elem] = true
+	}
+	return true
+}
+
+func checkEmail(field reflect.Value, _ string) bool {
+	return Matches(field.String(), EmailRX)
+}
+
+func checkPositive(field reflect.Value, _ string) bool {
+	return size(field) > 0
+}
@@ -0,0 +1,337 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codes returns the error codes recorded for each key, which is all most of the
+// tests need to look at.
+func codes(v *Validator) map[string][]string {
+	got := make(map[string][]string, len(v.Errors))
+	for key, fieldErrors := range v.Errors {
+		for _, fe := range fieldErrors {
+			got[key] = append(got[key], fe.Code)
+		}
+	}
+	return got
+}
+
+func TestStruct(t *testing.T) {
+	type director struct {
+		Name string `json:"name" validate:"required"`
+	}
+
+	type movie struct {
+		Title    string    `json:"title" validate:"required,max=10"`
+		Year     int       `json:"year" validate:"min=1888,max=2100"`
+		Rating   *float64  `json:"rating" validate:"min=0,max=10"`
+		Code     string    `validate:"len=3"`
+		Sort     string    `json:"sort,omitempty" validate:"oneof=asc desc"`
+		Genres   []string  `json:"genres" validate:"required,min=1,max=3,unique,dive,required,max=5"`
+		Contact  string    `json:"contact" validate:"email"`
+		Runtime  int       `json:"runtime" validate:"positive"`
+		Director *director `json:"director"`
+		Crew     []director
+		Ignored  string `json:"ignored" validate:"-"`
+		internal string `validate:"required"`
+	}
+
+	rating := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name  string
+		input movie
+		want  map[string][]string
+	}{
+		{
+			name: "valid",
+			input: movie{
+				Title: "Casablanca", Year: 1942, Rating: rating(8.5), Code: "abc", Sort: "asc",
+				Genres: []string{"drama"}, Contact: "alice@example.com", Runtime: 102,
+				Director: &director{Name: "Curtiz"},
+			},
+			want: map[string][]string{},
+		},
+		{
+			name:  "zero values",
+			input: movie{},
+			want: map[string][]string{
+				"title":   {"required"},
+				"year":    {"min"},
+				"Code":    {"len"},
+				"sort":    {"oneof"},
+				"genres":  {"required", "min"},
+				"contact": {"email"},
+				"runtime": {"positive"},
+			},
+		},
+		{
+			name: "too big",
+			input: movie{
+				Title: "Casablanca!", Year: 2101, Rating: rating(11), Code: "abcd", Sort: "up",
+				Genres: []string{"a", "b", "c", "d"}, Contact: "alice", Runtime: -1,
+			},
+			want: map[string][]string{
+				"title":   {"max"},
+				"year":    {"max"},
+				"rating":  {"max"},
+				"Code":    {"len"},
+				"sort":    {"oneof"},
+				"genres":  {"max"},
+				"contact": {"email"},
+				"runtime": {"positive"},
+			},
+		},
+		{
+			name: "dive and nested structs",
+			input: movie{
+				Title: "Casablanca", Year: 1942, Code: "abc", Sort: "desc", Contact: "a@b.com", Runtime: 1,
+				Genres:   []string{"drama", "", "westerns", "drama"},
+				Director: &director{},
+				Crew:     []director{{Name: "x"}, {}},
+			},
+			want: map[string][]string{
+				"genres":        {"max", "unique"},
+				"genres/1":      {"required"},
+				"genres/2":      {"max"},
+				"director/name": {"required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			v.Struct(&tt.input)
+
+			if got := codes(v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got errors %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructMessagesAndParams(t *testing.T) {
+	var input struct {
+		Title  string   `json:"title" validate:"max=3"`
+		Genres []string `json:"genres" validate:"min=1"`
+		Tags   []string `json:"tags" validate:"min=2"`
+		Sort   string   `json:"sort" validate:"oneof=asc  desc"`
+	}
+	input.Title = "Casablanca"
+	input.Genres = []string{}
+	input.Tags = []string{"a"}
+	input.Sort = "up"
+
+	v := New()
+	v.Struct(input)
+
+	tests := []struct {
+		key     string
+		message string
+		params  map[string]string
+	}{
+		{"title", "must not be more than 3 bytes long", map[string]string{"max": "3", "kind": "string"}},
+		{"genres", "must contain at least 1 item", map[string]string{"min": "1", "kind": "items"}},
+		{"tags", "must contain at least 2 items", map[string]string{"min": "2", "kind": "items"}},
+		{"sort", "must be one of: asc, desc", map[string]string{"values": "asc, desc", "kind": "string"}},
+	}
+
+	for _, tt := range tests {
+		fieldErrors := v.Errors[tt.key]
+		if len(fieldErrors) != 1 {
+			t.Fatalf("%s: got %d errors; want 1", tt.key, len(fieldErrors))
+		}
+		if fieldErrors[0].Message != tt.message {
+			t.Errorf("%s: got message %q; want %q", tt.key, fieldErrors[0].Message, tt.message)
+		}
+		if !reflect.DeepEqual(fieldErrors[0].Params, tt.params) {
+			t.Errorf("%s: got params %v; want %v", tt.key, fieldErrors[0].Params, tt.params)
+		}
+	}
+}
+
+func TestStructNilPointer(t *testing.T) {
+	var input struct {
+		Required *string `json:"required" validate:"required"`
+		Optional *string `json:"optional" validate:"min=3"`
+	}
+
+	v := New()
+	v.Struct(&input)
+
+	want := map[string][]string{"required": {"required"}}
+	if got := codes(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %v; want %v", got, want)
+	}
+
+	//A nil pointer to a struct has nothing to check
+	v = New()
+	v.Struct((*struct{})(nil))
+	if !v.Valid() {
+		t.Errorf("got errors %v for a nil pointer; want none", v.Errors)
+	}
+}
+
+func TestStructPointerEscaping(t *testing.T) {
+	var input struct {
+		Inner struct {
+			Value string `json:"a/b~c" validate:"required"`
+		} `json:"inner"`
+	}
+
+	v := New()
+	v.Struct(&input)
+
+	if _, ok := v.Errors["inner/a~1b~0c"]; !ok {
+		t.Errorf("got errors %v; want an error keyed %q", v.Errors, "inner/a~1b~0c")
+	}
+}
+
+func TestUnique(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  bool
+	}{
+		{"strings", []string{"a", "b"}, true},
+		{"duplicate strings", []string{"a", "b", "a"}, false},
+		{"array", [3]int{1, 2, 3}, true},
+		{"empty", []int{}, true},
+		{"slices", [][]string{{"a"}, {"a", "b"}}, true},
+		{"duplicate slices", [][]string{{"a", "b"}, {"a", "b"}}, false},
+		{"maps", []map[string]int{{"a": 1}, {"a": 2}}, true},
+		{"duplicate maps", []map[string]int{{"a": 1}, {"a": 1}}, false},
+		{"interfaces holding slices", []any{"a", []int{1}, []int{2}}, true},
+		{"duplicate interfaces holding slices", []any{[]int{1}, "a", []int{1}}, false},
+		{"duplicate interfaces holding strings", []any{"a", "a"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkUnique(reflect.ValueOf(tt.value), ""); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule("even", Rule{
+		Check:   func(field reflect.Value, _ string) bool { return field.Int()%2 == 0 },
+		Message: func(reflect.Value, string) string { return "must be even" },
+	})
+
+	var input struct {
+		N int `json:"n" validate:"even"`
+	}
+	input.N = 3
+
+	v := New()
+	v.Struct(&input)
+
+	want := []FieldError{{Code: "even", Message: "must be even"}}
+	if !reflect.DeepEqual(v.Errors["n"], want) {
+		t.Errorf("got %v; want %v", v.Errors["n"], want)
+	}
+}
+
+func TestPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+		want string
+	}{
+		{
+			name: "unknown rule",
+			fn: func() {
+				New().Struct(struct {
+					A string `validate:"nope"`
+				}{})
+			},
+			want: `unknown rule "nope"`,
+		},
+		{
+			name: "non-struct",
+			fn:   func() { New().Struct(42) },
+			want: "non-struct type int",
+		},
+		{
+			name: "dive on a string",
+			fn: func() {
+				New().Struct(struct {
+					A string `validate:"dive,required"`
+				}{})
+			},
+			want: "dive used on non-slice",
+		},
+		{
+			name: "bad parameter",
+			fn: func() {
+				New().Struct(struct {
+					A string `validate:"max=ten"`
+				}{})
+			},
+			want: "invalid numeric rule parameter",
+		},
+		{
+			name: "reserved rule name",
+			fn:   func() { RegisterRule("dive", Rule{Check: checkRequired, Message: fixed("")}) },
+			want: "invalid rule name",
+		},
+		{
+			name: "rule without a message",
+			fn:   func() { RegisterRule("incomplete", Rule{Check: checkRequired}) },
+			want: "must have a Check and a Message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					t.Fatal("expected a panic")
+				}
+				if msg, _ := err.(string); !strings.Contains(msg, tt.want) {
+					t.Errorf("got panic %q; want it to contain %q", msg, tt.want)
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
+
+func TestAddErrorDeduplicates(t *testing.T) {
+	v := New()
+	v.AddError("title", "required", "must be provided")
+	v.AddError("title", "required", "must be provided")
+	v.Check(false, "title", "max", "must not be more than 500 bytes long", "max", "500")
+
+	want := []FieldError{
+		{Code: "required", Message: "must be provided"},
+		{Code: "max", Message: "must not be more than 500 bytes long", Params: map[string]string{"max": "500"}},
+	}
+	if !reflect.DeepEqual(v.Errors["title"], want) {
+		t.Errorf("got %v; want %v", v.Errors["title"], want)
+	}
+}
+
+func TestVar(t *testing.T) {
+	v := New()
+	v.Var("", "email", "required,email")
+	v.Var("year", "sort", "oneof=id -id")
+	v.Var([]string{"a", "b"}, "tags", "dive,oneof=a c")
+	v.Var(5, "page", "min=1,max=10")
+
+	want := map[string][]string{
+		"email":  {"required", "email"},
+		"sort":   {"oneof"},
+		"tags/1": {"oneof"},
+	}
+	if got := codes(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %v; want %v", got, want)
+	}
+}
@@ -1,6 +1,11 @@
 package validator
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
 
 //Declare a regular expression for sanity checking the format of email addresses
 
@@ -8,46 +13,93 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// FieldError describes a single failed validation rule. Code is a stable, machine
+// readable identifier for the rule (e.g. "required" or "max") which clients can switch
+// on, and Message is the human readable (English) explanation. Params holds the named
+// values the message was built from, such as {"max": "500"}, so the message can be
+// rendered again in another language from a catalogue keyed by Code
+type FieldError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// New validator type which contains a map of validation errors. Each key can hold
+// several errors, so a field which breaks more than one rule reports all of them
+type Validator struct {
+	Errors map[string][]FieldError
+}
 
-//New validator type which contains a map of validation errors
-type Validator struct{
-	Errors map[string]string
+// New is a helper which creates a new validator instance with an empty errors map
+func New() *Validator {
+	return &Validator{Errors: make(map[string][]FieldError)}
 }
 
-//New is a helper which creates a new validator instance with an empty errors map
-func New() *Validator{
-	return &Validator{Errors: make(map[string]string)}
+// Valid returns true if the errors map doesn't contain any entries
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
 }
 
-//Valid returns true if the errors map doesn't contain any entries
-func (v *Validator)Valid()bool{
-	return len(v.Errors)==0
+// AddError appends an error to the list for the given key. The same code is only
+// recorded once per key, so running a check twice doesn't duplicate the message.
+// Any params are given as alternating name/value pairs, e.g. "max", "500"
+func (v *Validator) AddError(key, code, message string, params ...string) {
+	for _, e := range v.Errors[key] {
+		if e.Code == code {
+			return
+		}
+	}
+
+	fe := FieldError{Code: code, Message: message}
+	if len(params) > 0 {
+		fe.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			fe.Params[params[i]] = params[i+1]
+		}
+	}
+	v.Errors[key] = append(v.Errors[key], fe)
 }
 
-//AddError adds an error message to the map (as long as no entry exists for the given key)
-func (v *Validator) AddError(key,message string){
-	_,exists:=v.Errors[key]
-	if !exists{
-		v.Errors[key]=message
+// Check adds an error to the map only if a validation check is not ok
+func (v *Validator) Check(ok bool, key, code, message string, params ...string) {
+	if !ok {
+		v.AddError(key, code, message, params...)
 	}
 }
 
-//Check adds an error message to the map only if a validation check is not ok
-func(v *Validator)Check(ok bool,key, message string){
-	if !ok{
-		v.AddError(key,message)
+// Pointer builds an error key addressing a nested object field or slice element, in
+// the style of a JSON pointer (RFC 6901) without the leading slash: Pointer("genres", 2)
+// returns "genres/2" and Pointer("director", "name") returns "director/name". Any "~"
+// or "/" characters in a token are escaped as "~0" and "~1" respectively
+func Pointer(tokens ...any) string {
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		escaped[i] = pointerEscaper.Replace(fmt.Sprint(token))
 	}
+	return strings.Join(escaped, "/")
 }
 
-//If the number of unique value appears twice
-//the map just keeps one of them
-//if the number of unique items (in the map) is equal to the original slice length, all
-//values were unique
-func Unique[T comparable](values []T)bool{
-	uniqueValues:=make(map[T]bool)
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
 
-	for _,value:=range values{
-		uniqueValues[value]=true
+// Matches returns true if a string value matches a specific regexp pattern
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+// PermittedValue returns true if a specific value is in a list of permitted values
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
+
+// If the number of unique value appears twice
+// the map just keeps one of them
+// if the number of unique items (in the map) is equal to the original slice length, all
+// values were unique
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
+
+	for _, value := range values {
+		uniqueValues[value] = true
 	}
-	 return len(uniqueValues)==len(values)
-}
\ No newline at end of file
+	return len(uniqueValues) == len(values)
+}